@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const bundleVersion = 1
+
+// BundleStore keeps every entry in a single file at Path. A bundle can be built ahead of time and shipped in a read-only container image, since reading never writes.
+type BundleStore struct {
+	Path string
+}
+
+type bundle struct {
+	Version int              `json:"version"`
+	Entries map[string]Entry `json:"entries"`
+}
+
+func NewBundleStore(path string) *BundleStore {
+	return &BundleStore{Path: path}
+}
+
+func (store *BundleStore) Get(name string) (*Entry, error) {
+	b, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := b.Entries[name]
+	if !ok {
+		return nil, ErrNoEntry
+	}
+	return &entry, nil
+}
+
+func (store *BundleStore) Put(name string, entry *Entry) error {
+	return store.update(func(b *bundle) error {
+		b.Entries[name] = *entry
+		return nil
+	})
+}
+
+func (store *BundleStore) Delete(name string) error {
+	return store.update(func(b *bundle) error {
+		if _, ok := b.Entries[name]; !ok {
+			return ErrNoEntry
+		}
+		delete(b.Entries, name)
+		return nil
+	})
+}
+
+func (store *BundleStore) List() ([]*EntryInfo, error) {
+	b, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []*EntryInfo{}
+	for name, entry := range b.Entries {
+		infos = append(infos, entry.info(name, int64(len(entry.Body))))
+	}
+	sortInfos(infos)
+	return infos, nil
+}
+
+// read returns an empty bundle if the file doesn't exist yet.
+func (store *BundleStore) read() (*bundle, error) {
+	b := &bundle{Version: bundleVersion, Entries: map[string]Entry{}}
+
+	err := checkDir(filepath.Dir(store.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	data, _, err := readNoFollow(store.Path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntegrity, err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("%w: bundle version %d", ErrUnsupportedVersion, b.Version)
+	}
+	if b.Entries == nil {
+		b.Entries = map[string]Entry{}
+	}
+
+	return b, nil
+}
+
+// update rewrites the whole bundle under a lock.
+func (store *BundleStore) update(fn func(*bundle) error) error {
+	dir, name := filepath.Dir(store.Path), filepath.Base(store.Path)
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	err = checkDir(dir)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockPath(filepath.Join(dir, "."+name+".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock(lock)
+
+	b, err := store.read()
+	if err != nil {
+		return err
+	}
+
+	err = fn(b)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(store.Path, data)
+}
@@ -1,19 +1,38 @@
 package cache
 
 import (
-	"io/ioutil"
+	"errors"
 	"os"
 	"path/filepath"
 
 	"github.com/mitchellh/go-homedir"
 )
 
+// Cache seals and verifies entries and keeps them in a Store. Entries are stored under Filename(envkeyParam), so stores never see ENVKEY identifiers.
+// When an entry is replaced, the previous one is kept as an earlier generation, up to Generations in all (DefaultGenerations if 0).
 type Cache struct {
-	Dir  string
-	Done chan error
+	Dir         string
+	Generations int
+
+	store Store
 }
 
+// DefaultPath is $ENVKEY_CACHE_DIR if it's set, then $XDG_CACHE_HOME/envkey if XDG_CACHE_HOME is an absolute path, then ~/.envkey/cache.
 func DefaultPath() (string, error) {
+	if dir := os.Getenv("ENVKEY_CACHE_DIR"); dir != "" {
+		return homedir.Expand(dir)
+	}
+
+	// relative paths are invalid under the XDG base directory spec and should be ignored
+	if xdg := os.Getenv("XDG_CACHE_HOME"); filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, "envkey"), nil
+	}
+
+	return legacyPath()
+}
+
+// legacyPath is ~/.envkey/cache, where the cache was kept before $ENVKEY_CACHE_DIR and $XDG_CACHE_HOME were honored.
+func legacyPath() (string, error) {
 	home, err := homedir.Dir()
 	if err != nil {
 		return "", err
@@ -21,6 +40,35 @@ func DefaultPath() (string, error) {
 	return filepath.Join(home, ".envkey", "cache"), nil
 }
 
+// migrateLegacyDir moves ~/.envkey/cache to dir if dir doesn't exist yet, so entries cached before $XDG_CACHE_HOME was honored aren't lost. A legacy directory that fails checkDir is left alone. One that can't be moved, for example because dir is on another filesystem, stays in use.
+func migrateLegacyDir(dir string) string {
+	legacyDir, err := legacyPath()
+	if err != nil || legacyDir == dir {
+		return dir
+	}
+
+	if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+		return dir
+	}
+	if _, err := os.Lstat(legacyDir); err != nil || checkDir(legacyDir) != nil {
+		return dir
+	}
+
+	err = os.MkdirAll(filepath.Dir(dir), 0700)
+	if err == nil {
+		err = os.Rename(legacyDir, dir)
+	}
+	if err != nil {
+		// another process may have moved it first
+		if _, statErr := os.Lstat(dir); statErr == nil {
+			return dir
+		}
+		return legacyDir
+	}
+	return dir
+}
+
+// NewCache returns a cache kept in files in dir, or in DefaultPath if dir is empty. When that's under $XDG_CACHE_HOME, an existing ~/.envkey/cache is moved there the first time.
 func NewCache(dir string) (*Cache, error) {
 	var withDir string
 	var err error
@@ -30,6 +78,9 @@ func NewCache(dir string) (*Cache, error) {
 		if err != nil {
 			return nil, err
 		}
+		if os.Getenv("ENVKEY_CACHE_DIR") == "" {
+			withDir = migrateLegacyDir(withDir)
+		}
 
 	} else {
 		withDir, err = homedir.Expand(dir)
@@ -37,48 +88,107 @@ func NewCache(dir string) (*Cache, error) {
 			return nil, err
 		}
 	}
-	return &Cache{withDir, make(chan error, 1)}, nil
+	return &Cache{Dir: withDir, store: NewFileStore(withDir)}, nil
 }
 
-func (cache *Cache) Write(envkeyParam string, body []byte) error {
-	var err error
+// NewCacheWithStore returns a cache kept in store. Dir is only set for a *FileStore.
+func NewCacheWithStore(store Store) *Cache {
+	var dir string
+	if fileStore, ok := store.(*FileStore); ok {
+		dir = fileStore.Dir
+	}
+	return &Cache{Dir: dir, store: store}
+}
 
-	// ensure dir exists
-	err = os.MkdirAll(cache.Dir, 0700)
-	if err != nil {
-		select {
-		case cache.Done <- err:
-		default:
+func (cache *Cache) Store() Store {
+	return cache.store
+}
+
+// Write seals entry with an HMAC keyed from pw and stores it. Only write entries that have been verified, since earlier generations are dropped as new ones are written.
+func (cache *Cache) Write(envkeyParam string, pw []byte, entry *Entry) error {
+	name := Filename(envkeyParam)
+	entry.seal(pw)
+
+	if cache.generations() > 1 {
+		err := cache.keepPrevious(name, pw, entry)
+		if err != nil {
+			return err
 		}
+	}
+
+	err := cache.store.Put(name, entry)
+	if err != nil {
 		return err
 	}
 
-	dir := filepath.Join(cache.Dir, envkeyParam)
-	err = ioutil.WriteFile(dir, body, 0600)
+	return cache.pruneHistory(name)
+}
+
+// Path is where the entry for envkeyParam is stored, if the cache is kept in files.
+func (cache *Cache) Path(envkeyParam string) string {
+	return filepath.Join(cache.Dir, Filename(envkeyParam))
+}
 
-	select {
-	case cache.Done <- err:
-	default:
+// Read loads and verifies the entry for envkeyParam. An entry in the legacy file format (the raw response, named after envkeyParam) is migrated to the current format.
+func (cache *Cache) Read(envkeyParam string, pw []byte) (*Entry, error) {
+	entry, err := cache.store.Get(Filename(envkeyParam))
+
+	legacy, ok := cache.store.(legacyStore)
+	if errors.Is(err, ErrNoEntry) && ok {
+		return cache.migrate(legacy, envkeyParam, pw, err)
 	}
-	return err
+	if err != nil {
+		return nil, err
+	}
+
+	err = entry.verify(pw)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
 }
 
-func (cache *Cache) Read(envkeyParam string) ([]byte, error) {
-	path := filepath.Join(cache.Dir, envkeyParam)
-	b, err := ioutil.ReadFile(path)
-	select {
-	case cache.Done <- err:
-	default:
+func (cache *Cache) migrate(legacy legacyStore, envkeyParam string, pw []byte, noEntryErr error) (*Entry, error) {
+	entry, err := legacy.getLegacy(envkeyParam)
+	if errors.Is(err, ErrNoEntry) {
+		return nil, noEntryErr
+	}
+	if err != nil {
+		return nil, err
 	}
-	return b, err
+
+	entry.seal(pw)
+
+	// if migration fails, the legacy entry is left in place for next time
+	if cache.store.Put(Filename(envkeyParam), entry) == nil {
+		legacy.deleteLegacy(envkeyParam)
+	}
+
+	return entry, nil
 }
 
+// Delete removes the entry for envkeyParam, along with its earlier generations and any legacy entry.
 func (cache *Cache) Delete(envkeyParam string) error {
-	path := filepath.Join(cache.Dir, envkeyParam)
-	err := os.Remove(path)
-	select {
-	case cache.Done <- err:
-	default:
+	name := Filename(envkeyParam)
+	err := cache.store.Delete(name)
+
+	infos, historyErr := cache.historyInfos(name)
+	for _, info := range infos {
+		if historyErr == nil {
+			historyErr = cache.store.Delete(info.Name)
+		}
+	}
+	if errors.Is(err, ErrNoEntry) && len(infos) > 0 {
+		err = historyErr
+	} else if err == nil {
+		err = historyErr
+	}
+
+	if legacy, ok := cache.store.(legacyStore); ok {
+		legacyErr := legacy.deleteLegacy(envkeyParam)
+		if errors.Is(err, ErrNoEntry) && legacyErr == nil {
+			err = nil
+		}
 	}
 	return err
 }
@@ -1,10 +1,16 @@
 package cache_test
 
 import (
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/envkey/envkey-fetch/cache"
 
@@ -22,57 +28,413 @@ func TestNewCache(t *testing.T) {
 
 	// with no dir (default to homedir/.envkey/cache)
 	c, _ = cache.NewCache("")
-	assert.NotNil(t, c.Done, "done channel initialized")
 	assert.Equal(t, c.Dir, (home + "/.envkey/cache"), "default dir is homedir/.envkey/cache")
 
 	// with supplied dir
 	c, _ = cache.NewCache("/dev/null")
-	assert.NotNil(t, c.Done, "done channel initialized")
 	assert.Equal(t, c.Dir, "/dev/null", "sets the dir")
 
 	// homedir expansion
 	c, _ = cache.NewCache("~/.envkey/cache/test")
-	assert.NotNil(t, c.Done, "done channel initialized")
 	assert.Equal(t, c.Dir, filepath.Join(home, ".envkey", "cache", "test"), "default dir is correctly expanded")
 }
 
+var testPw = []byte("some-pw")
+
 func TestWrite(t *testing.T) {
 	c, _ := cache.NewCache(testPath)
-	err := c.Write("some-envkey", []byte("test data"))
+	fetchedAt := time.Now()
+	err := c.Write("some-envkey", testPw, &cache.Entry{FetchedAt: fetchedAt, SourceUrl: "https://env.envkey.com/v1/some-envkey", ETag: `"etag"`, Body: []byte("test data")})
 
 	assert.Nil(t, err, "Should not return an error.")
-	assert.Equal(t, 1, len(c.Done), "Should add to done channel")
+	defer os.Remove(c.Path("some-envkey"))
 
-	res, err := ioutil.ReadFile(filepath.Join(testPathExpanded, "some-envkey"))
-	assert.Equal(t, "test data", string(res), "Should correctly write to the file.")
+	assert.Equal(t, filepath.Join(testPathExpanded, cache.Filename("some-envkey")), c.Path("some-envkey"), "Should hash the filename.")
+	assert.NotContains(t, c.Path("some-envkey"), "some-envkey", "Should not reveal the envkey param.")
 
-	go os.Remove(filepath.Join(testPath, "some-envkey"))
+	var entry cache.Entry
+	res, err := ioutil.ReadFile(c.Path("some-envkey"))
+	assert.Nil(t, json.Unmarshal(res, &entry), "Should write a json envelope.")
+	assert.Equal(t, cache.FormatVersion, entry.Version, "Should set the format version.")
+	assert.Equal(t, "test data", string(entry.Body), "Should correctly write the body.")
+	assert.Equal(t, `"etag"`, entry.ETag, "Should write the etag.")
+	assert.True(t, fetchedAt.Equal(entry.FetchedAt), "Should write the fetched at time.")
+	assert.NotEmpty(t, entry.Hmac, "Should write an hmac.")
 }
 
 func TestRead(t *testing.T) {
 	writeCache, _ := cache.NewCache(testPath)
-	writeCache.Write("some-envkey", []byte("test data"))
+	writeCache.Write("some-envkey", testPw, &cache.Entry{FetchedAt: time.Now(), SourceUrl: "https://env.envkey.com/v1/some-envkey", Body: []byte("test data")})
+	defer os.Remove(writeCache.Path("some-envkey"))
 
 	c, _ := cache.NewCache(testPath)
-	res, err := c.Read("some-envkey")
+	entry, err := c.Read("some-envkey", testPw)
 	assert.Nil(t, err, "Should not return an error.")
-	assert.Equal(t, "test data", string(res), "Should correctly read from the file.")
-	assert.Equal(t, 1, len(c.Done), "Should add to done channel")
+	assert.Equal(t, "test data", string(entry.Body), "Should correctly read from the file.")
+	assert.Equal(t, "https://env.envkey.com/v1/some-envkey", entry.SourceUrl, "Should read the source url.")
+
+	_, err = c.Read("some-envkey", []byte("wrong-pw"))
+	assert.True(t, errors.Is(err, cache.ErrIntegrity), "Should fail the integrity check with the wrong passphrase.")
+}
+
+func TestReadTampered(t *testing.T) {
+	c, _ := cache.NewCache(testPath)
+	c.Write("some-envkey", testPw, &cache.Entry{FetchedAt: time.Now(), Body: []byte("test data")})
+	defer os.Remove(c.Path("some-envkey"))
+
+	var entry cache.Entry
+	res, _ := ioutil.ReadFile(c.Path("some-envkey"))
+	json.Unmarshal(res, &entry)
+
+	entry.Body = []byte("tampered")
+	res, _ = json.Marshal(entry)
+	ioutil.WriteFile(c.Path("some-envkey"), res, 0600)
+	_, err := c.Read("some-envkey", testPw)
+	assert.True(t, errors.Is(err, cache.ErrIntegrity), "Should detect a modified body.")
+
+	entry.Version = cache.FormatVersion + 1
+	res, _ = json.Marshal(entry)
+	ioutil.WriteFile(c.Path("some-envkey"), res, 0600)
+	_, err = c.Read("some-envkey", testPw)
+	assert.True(t, errors.Is(err, cache.ErrUnsupportedVersion), "Should reject an unknown format version.")
+
+	ioutil.WriteFile(c.Path("some-envkey"), []byte("not json"), 0600)
+	_, err = c.Read("some-envkey", testPw)
+	assert.True(t, errors.Is(err, cache.ErrIntegrity), "Should reject a corrupt file.")
+}
+
+func TestReadMigratesLegacyFormat(t *testing.T) {
+	c, _ := cache.NewCache(testPath)
+	os.MkdirAll(testPathExpanded, 0700)
+	legacyPath := filepath.Join(testPathExpanded, "legacy-envkey")
+	ioutil.WriteFile(legacyPath, []byte("test data"), 0600)
+	defer os.Remove(c.Path("legacy-envkey"))
+
+	entry, err := c.Read("legacy-envkey", testPw)
+	assert.Nil(t, err, "Should not return an error.")
+	assert.Equal(t, "test data", string(entry.Body), "Should read the legacy file.")
+
+	_, err = os.Stat(legacyPath)
+	assert.True(t, os.IsNotExist(err), "Should remove the legacy file.")
+
+	entry, err = c.Read("legacy-envkey", testPw)
+	assert.Nil(t, err, "Should read the migrated entry.")
+	assert.Equal(t, "test data", string(entry.Body), "Should keep the body when migrating.")
 }
 
 func TestDelete(t *testing.T) {
 	var err error
 
 	writeCache, _ := cache.NewCache(testPath)
-	writeCache.Write("some-envkey", []byte("test data"))
+	writeCache.Write("some-envkey", testPw, &cache.Entry{Body: []byte("test data")})
 
 	c, _ := cache.NewCache(testPath)
 	err = c.Delete("some-envkey")
 
 	assert.Nil(t, err, "Should not return an error.")
-	assert.Equal(t, 1, len(c.Done), "Should add to done channel")
 
-	_, err = ioutil.ReadFile(filepath.Join(testPath, "some-envkey"))
+	_, err = ioutil.ReadFile(c.Path("some-envkey"))
 	assert.NotNil(t, err, "Should have removed the cache file.")
 
+	ioutil.WriteFile(filepath.Join(testPathExpanded, "legacy-envkey"), []byte("test data"), 0600)
+	err = c.Delete("legacy-envkey")
+	assert.Nil(t, err, "Should not return an error.")
+
+	_, err = ioutil.ReadFile(filepath.Join(testPathExpanded, "legacy-envkey"))
+	assert.NotNil(t, err, "Should have removed the legacy cache file.")
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	var wg sync.WaitGroup
+	bodies := map[string]bool{}
+
+	for i := 0; i < 20; i++ {
+		// large enough that a torn write would be noticed
+		body := strings.Repeat(string(rune('a'+i)), 256*1024)
+		bodies[body] = true
+
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			c, _ := cache.NewCache(testPath)
+			assert.Nil(t, c.Write("concurrent-envkey", testPw, &cache.Entry{Body: []byte(body)}), "Should not return an error.")
+		}(body)
+	}
+	wg.Wait()
+
+	c, _ := cache.NewCache(testPath)
+	defer c.Delete("concurrent-envkey")
+
+	entry, err := c.Read("concurrent-envkey", testPw)
+	assert.Nil(t, err, "Should not return an error.")
+	assert.True(t, bodies[string(entry.Body)], "Should contain exactly one complete write.")
+
+	tmpFiles, _ := filepath.Glob(filepath.Join(testPathExpanded, "."+cache.Filename("concurrent-envkey")+".tmp-*"))
+	assert.Empty(t, tmpFiles, "Should not leave temp files behind.")
+}
+
+func TestList(t *testing.T) {
+	dir := filepath.Join(testPath, "list")
+	c, _ := cache.NewCache(dir)
+	defer os.RemoveAll(c.Dir)
+
+	c.Write("new-envkey", testPw, &cache.Entry{FetchedAt: time.Now(), SourceUrl: "https://env.envkey.com/v1/new-envkey", Body: []byte("new")})
+	c.Write("old-envkey", testPw, &cache.Entry{FetchedAt: time.Now().Add(-2 * time.Hour), Body: []byte("old")})
+	ioutil.WriteFile(filepath.Join(c.Dir, "legacyenvkey"), []byte("legacy"), 0600)
+	os.Chtimes(filepath.Join(c.Dir, "legacyenvkey"), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+	infos, err := c.List()
+	assert.Nil(t, err, "Should not return an error.")
+	if !assert.Equal(t, 3, len(infos), "Should list entries, skipping lock files.") {
+		return
+	}
+
+	assert.Equal(t, cache.Filename("new-envkey"), infos[0].Name, "Should list newest first.")
+	assert.Equal(t, "https://env.envkey.com/v1/new-envkey", infos[0].SourceUrl, "Should read metadata.")
+	assert.Equal(t, cache.Filename("legacyenvkey"), infos[1].Name, "Should list legacy entries by hashed name.")
+	assert.True(t, infos[1].Legacy, "Should flag legacy entries.")
+	assert.Equal(t, cache.Filename("old-envkey"), infos[2].Name)
+
+	info, err := c.Find(cache.Filename("old-envkey")[:12])
+	assert.Nil(t, err, "Should find an entry by prefix.")
+	assert.Equal(t, infos[2].Name, info.Name)
+
+	_, err = c.Find("zzz")
+	assert.True(t, errors.Is(err, cache.ErrNoEntry), "Should fail without a match.")
+}
+
+func TestPruneAndClear(t *testing.T) {
+	dir := filepath.Join(testPath, "prune")
+	c, _ := cache.NewCache(dir)
+	defer os.RemoveAll(c.Dir)
+
+	c.Write("new-envkey", testPw, &cache.Entry{FetchedAt: time.Now(), Body: []byte("new")})
+	c.Write("old-envkey", testPw, &cache.Entry{FetchedAt: time.Now().Add(-2 * time.Hour), Body: []byte("old")})
+
+	pruned, err := c.Prune(time.Hour)
+	assert.Nil(t, err, "Should not return an error.")
+	if assert.Equal(t, 1, len(pruned), "Should prune old entries.") {
+		assert.Equal(t, cache.Filename("old-envkey"), pruned[0].Name)
+	}
+
+	_, err = c.Read("new-envkey", testPw)
+	assert.Nil(t, err, "Should keep newer entries.")
+
+	n, err := c.Clear()
+	assert.Nil(t, err, "Should not return an error.")
+	assert.Equal(t, 1, n, "Should clear remaining entries.")
+
+	files, _ := ioutil.ReadDir(c.Dir)
+	assert.Empty(t, files, "Should remove lock files too.")
+}
+
+func TestStores(t *testing.T) {
+	bundlePath := filepath.Join(testPathExpanded, "stores", "cache.bundle")
+	defer os.RemoveAll(filepath.Join(testPathExpanded, "stores"))
+
+	for _, test := range []struct {
+		desc  string
+		store func() cache.Store
+	}{
+		{"file", func() cache.Store { return cache.NewFileStore(filepath.Join(testPathExpanded, "stores", "files")) }},
+		{"memory", func() cache.Store { return cache.NewMemoryStore() }},
+		{"bundle", func() cache.Store { return cache.NewBundleStore(bundlePath) }},
+	} {
+		c := cache.NewCacheWithStore(test.store())
+
+		_, err := c.Read("some-envkey", testPw)
+		assert.True(t, errors.Is(err, cache.ErrNoEntry), test.desc+": should fail for a missing entry")
+
+		assert.Nil(t, c.Write("some-envkey", testPw, &cache.Entry{FetchedAt: time.Now(), Body: []byte("test data")}), test.desc)
+		assert.Nil(t, c.Write("other-envkey", testPw, &cache.Entry{FetchedAt: time.Now().Add(-time.Hour), Body: []byte("other data")}), test.desc)
+
+		entry, err := c.Read("some-envkey", testPw)
+		if assert.Nil(t, err, test.desc) {
+			assert.Equal(t, "test data", string(entry.Body), test.desc)
+		}
+
+		_, err = c.Read("some-envkey", []byte("wrong-pw"))
+		assert.True(t, errors.Is(err, cache.ErrIntegrity), test.desc+": should check the hmac")
+
+		infos, err := c.List()
+		assert.Nil(t, err, test.desc)
+		if assert.Equal(t, 2, len(infos), test.desc) {
+			assert.Equal(t, cache.Filename("some-envkey"), infos[0].Name, test.desc+": should list newest first")
+			assert.Equal(t, int64(len("test data")) > 0, infos[0].Size > 0, test.desc)
+		}
+
+		assert.Nil(t, c.Delete("some-envkey"), test.desc)
+		_, err = c.Read("some-envkey", testPw)
+		assert.True(t, errors.Is(err, cache.ErrNoEntry), test.desc+": should delete")
+
+		n, err := c.Clear()
+		assert.Nil(t, err, test.desc)
+		assert.Equal(t, 1, n, test.desc)
+	}
+}
+
+func TestBundleStoreIsShared(t *testing.T) {
+	bundlePath := filepath.Join(testPathExpanded, "shared", "cache.bundle")
+	defer os.RemoveAll(filepath.Join(testPathExpanded, "shared"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := cache.NewCacheWithStore(cache.NewBundleStore(bundlePath))
+			assert.Nil(t, c.Write("envkey"+strconv.Itoa(i), testPw, &cache.Entry{Body: []byte("test data")}))
+		}(i)
+	}
+	wg.Wait()
+
+	c := cache.NewCacheWithStore(cache.NewBundleStore(bundlePath))
+	infos, err := c.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(infos), "Should keep every concurrent write.")
+}
+
+func TestGenerations(t *testing.T) {
+	c := cache.NewCacheWithStore(cache.NewMemoryStore())
+	c.Generations = 3
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, c.Write("some-envkey", testPw, &cache.Entry{FetchedAt: start.Add(time.Duration(i) * time.Minute), Body: []byte("data " + strconv.Itoa(i))}))
+	}
+	// an unchanged body doesn't make a new generation
+	assert.Nil(t, c.Write("some-envkey", testPw, &cache.Entry{FetchedAt: start.Add(5 * time.Minute), Body: []byte("data 3")}))
+
+	entries, err := c.History("some-envkey", testPw)
+	if assert.Nil(t, err) && assert.Equal(t, 3, len(entries), "Should keep the configured number of generations.") {
+		assert.Equal(t, "data 3", string(entries[0].Body))
+		assert.Equal(t, start.Add(5*time.Minute).UnixNano(), entries[0].FetchedAt.UnixNano(), "Should refresh the current entry.")
+		assert.Equal(t, "data 2", string(entries[1].Body))
+		assert.Equal(t, "data 1", string(entries[2].Body), "Should drop the oldest generation.")
+	}
+
+	entry, err := c.ReadGeneration("some-envkey", testPw, 1)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "data 2", string(entry.Body))
+	}
+
+	_, err = c.ReadGeneration("some-envkey", testPw, 3)
+	assert.True(t, errors.Is(err, cache.ErrNoEntry))
+
+	_, err = c.History("some-envkey", []byte("wrong-pw"))
+	assert.NotNil(t, err, "Should only return verified generations.")
+
+	infos, _ := c.List()
+	assert.Equal(t, 1, len(infos), "Should only list current entries.")
+
+	assert.Nil(t, c.Delete("some-envkey"))
+	_, err = c.History("some-envkey", testPw)
+	assert.True(t, errors.Is(err, cache.ErrNoEntry), "Should delete every generation.")
+}
+
+func TestCheckVersion(t *testing.T) {
+	store := cache.NewMemoryStore()
+	c := cache.NewCacheWithStore(store)
+
+	assert.Nil(t, c.CheckVersion("some-envkey", testPw, []byte("v1")))
+	assert.Nil(t, c.CheckVersion("some-envkey", testPw, []byte("v1")), "Should accept the latest version again.")
+	assert.Nil(t, c.CheckVersion("some-envkey", testPw, []byte("v2")))
+
+	err := c.CheckVersion("some-envkey", testPw, []byte("v1"))
+	var replayErr *cache.ReplayError
+	if assert.True(t, errors.As(err, &replayErr), "Should catch a replayed version.") {
+		assert.True(t, errors.Is(err, cache.ErrReplay))
+		assert.False(t, replayErr.NewerSeen.Before(replayErr.FirstSeen))
+	}
+
+	versions, err := c.Versions("some-envkey", testPw)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions), "Should not record a replayed version.")
+
+	infos, _ := c.List()
+	assert.Equal(t, 0, len(infos), "Should not list version logs as entries.")
+
+	// the log is sealed like an entry
+	err = c.CheckVersion("some-envkey", []byte("wrong-pw"), []byte("v3"))
+	assert.True(t, errors.Is(err, cache.ErrIntegrity), "Should check the log's hmac.")
+
+	all, _ := store.List()
+	log, _ := store.Get(all[0].Name)
+	var tampered []cache.Version
+	json.Unmarshal(log.Body, &tampered)
+	tampered[0], tampered[1] = tampered[1], tampered[0]
+	log.Body, _ = json.Marshal(tampered)
+	store.Put(all[0].Name, log)
+	_, err = c.Versions("some-envkey", testPw)
+	assert.True(t, errors.Is(err, cache.ErrIntegrity), "Should reject a modified log.")
+}
+
+func TestCheckVersionConcurrent(t *testing.T) {
+	dir := filepath.Join(testPath, "versions")
+	c, _ := cache.NewCache(dir)
+	defer os.RemoveAll(c.Dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, c.CheckVersion("some-envkey", testPw, []byte("v"+strconv.Itoa(i))))
+		}(i)
+	}
+	wg.Wait()
+
+	versions, err := c.Versions("some-envkey", testPw)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, len(versions), "Should keep every version recorded by concurrent fetches.")
+
+	// another process updating the log
+	unlock, err := cache.LockPath(filepath.Join(c.Dir, "."+cache.Filename("some-envkey")+".versions.lock"))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.CheckVersion("some-envkey", testPw, []byte("v20"))
+	}()
+
+	select {
+	case <-done:
+		unlock()
+		t.Fatal("Should wait for the version log lock.")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	unlock()
+	assert.Nil(t, <-done)
+}
+
+func TestLockFetch(t *testing.T) {
+	c, _ := cache.NewCache(testPath)
+
+	unlock, err := c.LockFetch("some-envkey", time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = c.LockFetch("some-envkey", 100*time.Millisecond)
+	assert.True(t, errors.Is(err, cache.ErrLockTimeout), "Should time out while the lock is held.")
+
+	otherUnlock, err := c.LockFetch("other-envkey", 100*time.Millisecond)
+	if assert.Nil(t, err, "Should lock each ENVKEY separately.") {
+		otherUnlock()
+	}
+
+	go func(unlock func()) {
+		time.Sleep(100 * time.Millisecond)
+		unlock()
+	}(unlock)
+	unlock, err = c.LockFetch("some-envkey", time.Second)
+	if assert.Nil(t, err, "Should wait for the lock to be released.") {
+		unlock()
+	}
+
+	_, err = cache.NewCacheWithStore(cache.NewMemoryStore()).LockFetch("some-envkey", time.Second)
+	assert.True(t, errors.Is(err, cache.ErrNoLockDir))
 }
@@ -0,0 +1,163 @@
+package cache_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPath(t *testing.T) {
+	home, _ := homedir.Dir()
+	defer os.Unsetenv("ENVKEY_CACHE_DIR")
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	os.Setenv("ENVKEY_CACHE_DIR", "~/envkey-cache")
+	os.Setenv("XDG_CACHE_HOME", "/xdg/cache")
+	path, _ := cache.DefaultPath()
+	assert.Equal(t, filepath.Join(home, "envkey-cache"), path, "Should prefer $ENVKEY_CACHE_DIR.")
+
+	os.Unsetenv("ENVKEY_CACHE_DIR")
+	path, _ = cache.DefaultPath()
+	assert.Equal(t, filepath.Join("/xdg/cache", "envkey"), path, "Should use $XDG_CACHE_HOME.")
+
+	os.Setenv("XDG_CACHE_HOME", "relative/cache")
+	path, _ = cache.DefaultPath()
+	assert.Equal(t, filepath.Join(home, ".envkey", "cache"), path, "Should ignore a relative $XDG_CACHE_HOME.")
+}
+
+func TestMigrateLegacyDir(t *testing.T) {
+	home := filepath.Join(testPathExpanded, "home")
+	defer os.RemoveAll(home)
+	defer os.Unsetenv("XDG_CACHE_HOME")
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	defer func() { homedir.DisableCache = false }()
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", home)
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(home, "xdg"))
+
+	legacy, _ := cache.NewCache(filepath.Join(home, ".envkey", "cache"))
+	legacy.Write("some-envkey", testPw, &cache.Entry{FetchedAt: time.Now(), Body: []byte("test data")})
+
+	c, err := cache.NewCache("")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, filepath.Join(home, "xdg", "envkey"), c.Dir, "Should use $XDG_CACHE_HOME.")
+
+	entry, err := c.Read("some-envkey", testPw)
+	assert.Nil(t, err, "Should read entries cached in ~/.envkey/cache.")
+	if err == nil {
+		assert.Equal(t, "test data", string(entry.Body))
+	}
+	_, err = os.Stat(filepath.Join(home, ".envkey", "cache"))
+	assert.True(t, os.IsNotExist(err), "Should move ~/.envkey/cache.")
+
+	if runtime.GOOS != "windows" {
+		os.RemoveAll(filepath.Join(home, "xdg"))
+		legacy.Write("some-envkey", testPw, &cache.Entry{FetchedAt: time.Now(), Body: []byte("test data")})
+		os.Chmod(legacy.Dir, 0777)
+
+		c, _ = cache.NewCache("")
+		_, err = c.Read("some-envkey", testPw)
+		assert.True(t, errors.Is(err, cache.ErrNoEntry), "Should leave an insecure ~/.envkey/cache alone.")
+		_, err = os.Stat(legacy.Dir)
+		assert.Nil(t, err)
+	}
+}
+
+func TestInsecurePaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission checks are unix only")
+	}
+
+	root := filepath.Join(testPathExpanded, "secure")
+	defer os.RemoveAll(root)
+	entry := func() *cache.Entry { return &cache.Entry{FetchedAt: time.Now(), Body: []byte("test data")} }
+
+	t.Run("writable by others", func(t *testing.T) {
+		dir := filepath.Join(root, "writable")
+		os.MkdirAll(dir, 0700)
+		os.Chmod(dir, 0777)
+
+		c, _ := cache.NewCache(dir)
+		err := c.Write("some-envkey", testPw, entry())
+		assert.True(t, errors.Is(err, cache.ErrInsecure), "%v", err)
+		assert.Contains(t, err.Error(), "chmod go-w", "Should explain the fix.")
+	})
+
+	t.Run("symlinked dir", func(t *testing.T) {
+		real := filepath.Join(root, "real")
+		link := filepath.Join(root, "link")
+		os.MkdirAll(real, 0700)
+		os.Symlink(real, link)
+
+		c, _ := cache.NewCache(link)
+		err := c.Write("some-envkey", testPw, entry())
+		assert.True(t, errors.Is(err, cache.ErrInsecure), "%v", err)
+		_, err = c.Read("some-envkey", testPw)
+		assert.True(t, errors.Is(err, cache.ErrInsecure), "%v", err)
+	})
+
+	t.Run("symlinked entry", func(t *testing.T) {
+		dir := filepath.Join(root, "swapped")
+		c, _ := cache.NewCache(dir)
+		assert.Nil(t, c.Write("other-envkey", testPw, entry()))
+
+		// an entry that's been swapped for a link to another one
+		os.Symlink(c.Path("other-envkey"), c.Path("some-envkey"))
+		_, err := c.Read("some-envkey", testPw)
+		assert.True(t, errors.Is(err, cache.ErrInsecure), "%v", err)
+		assert.Contains(t, err.Error(), "symlink")
+
+		err = c.Delete("some-envkey")
+		assert.True(t, errors.Is(err, cache.ErrInsecure), "Should not follow the link when removing.")
+		_, err = c.Read("other-envkey", testPw)
+		assert.Nil(t, err)
+	})
+
+	t.Run("file writable by others", func(t *testing.T) {
+		dir := filepath.Join(root, "file")
+		c, _ := cache.NewCache(dir)
+		assert.Nil(t, c.Write("some-envkey", testPw, entry()))
+		os.Chmod(c.Path("some-envkey"), 0666)
+
+		_, err := c.Read("some-envkey", testPw)
+		assert.True(t, errors.Is(err, cache.ErrInsecure), "%v", err)
+	})
+
+	t.Run("owned by another user", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("needs root to chown")
+		}
+
+		dir := filepath.Join(root, "owner")
+		c, _ := cache.NewCache(dir)
+		assert.Nil(t, c.Write("some-envkey", testPw, entry()))
+		os.Chown(c.Path("some-envkey"), 1000, 1000)
+
+		_, err := c.Read("some-envkey", testPw)
+		assert.True(t, errors.Is(err, cache.ErrInsecure), "%v", err)
+		assert.Contains(t, err.Error(), "owned by uid 1000")
+	})
+
+	t.Run("bundle", func(t *testing.T) {
+		path := filepath.Join(root, "bundle", "cache.bundle")
+		c := cache.NewCacheWithStore(cache.NewBundleStore(path))
+		assert.Nil(t, c.Write("some-envkey", testPw, entry()))
+
+		real := filepath.Join(root, "bundle", "real.bundle")
+		os.Rename(path, real)
+		os.Symlink(real, path)
+		_, err := c.Read("some-envkey", testPw)
+		assert.True(t, errors.Is(err, cache.ErrInsecure), "%v", err)
+	})
+}
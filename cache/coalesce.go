@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockPollInterval = 50 * time.Millisecond
+
+var (
+	ErrLockTimeout = errors.New("timed out waiting for another fetch")
+	ErrNoLockDir   = errors.New("cache store isn't kept on disk")
+)
+
+// lockDirStore is implemented by stores kept on disk, where fetches can be coordinated between processes.
+type lockDirStore interface {
+	lockDir() string
+}
+
+func (store *FileStore) lockDir() string {
+	return store.Dir
+}
+
+func (store *BundleStore) lockDir() string {
+	return filepath.Dir(store.Path)
+}
+
+// LockFetch takes a lock shared by every process fetching envkeyParam into this cache, waiting up to timeout for it. It fails with ErrLockTimeout if the lock is still held after that, or ErrNoLockDir for a store that isn't kept on disk.
+// The lock is released by calling unlock, or when the process exits, so a process that dies while fetching doesn't hold up the others.
+func (cache *Cache) LockFetch(envkeyParam string, timeout time.Duration) (func(), error) {
+	store, ok := cache.store.(lockDirStore)
+	if !ok {
+		return nil, ErrNoLockDir
+	}
+
+	err := os.MkdirAll(store.lockDir(), 0700)
+	if err == nil {
+		err = checkDir(store.lockDir())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := openNoFollow(filepath.Join(store.lockDir(), "."+Filename(envkeyParam)+".fetch.lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			return func() { unlock(f) }, nil
+		}
+
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// LockPath takes an exclusive lock on the file at path, creating it if needed, and waits while another process holds it. Files kept outside the cache use it to serialize reading, changing and writing them back.
+// The lock is released by calling unlock, or when the process exits.
+func LockPath(path string) (func(), error) {
+	f, err := lockPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return func() { unlock(f) }, nil
+}
+
+// SetCoalescing records whether fetches of envkeyParam into this cache should wait for each other. It's turned off once a response that can't be cached is fetched, since waiters would only find no entry and fetch again, and back on once one can be.
+func (cache *Cache) SetCoalescing(envkeyParam string, on bool) error {
+	store, ok := cache.store.(lockDirStore)
+	if !ok {
+		return ErrNoLockDir
+	}
+
+	path := noCoalescePath(store, envkeyParam)
+	if on {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	err := os.MkdirAll(store.lockDir(), 0700)
+	if err == nil {
+		err = checkDir(store.lockDir())
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := openNoFollow(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Coalescing reports whether fetches of envkeyParam should wait for each other, which they do unless SetCoalescing turned it off.
+func (cache *Cache) Coalescing(envkeyParam string) bool {
+	store, ok := cache.store.(lockDirStore)
+	if !ok {
+		return true
+	}
+
+	_, err := os.Lstat(noCoalescePath(store, envkeyParam))
+	return os.IsNotExist(err)
+}
+
+func noCoalescePath(store lockDirStore, envkeyParam string) string {
+	return filepath.Join(store.lockDir(), "."+Filename(envkeyParam)+".nocoalesce")
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"time"
+
+	"github.com/envkey/envkey-fetch/internal/secmem"
+)
+
+// FormatVersion is the version of the cache file envelope written by this package.
+const FormatVersion = 1
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported cache format version")
+	ErrIntegrity          = errors.New("cache integrity check failed")
+)
+
+// Entry is a cached env service response along with where and when it was fetched.
+type Entry struct {
+	Version   int       `json:"version"`
+	FetchedAt time.Time `json:"fetched_at"`
+	SourceUrl string    `json:"source_url,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
+	Body      []byte    `json:"body"`
+	Hmac      string    `json:"hmac"`
+}
+
+// Filename hashes envkeyParam so the cache directory doesn't reveal ENVKEY identifiers.
+func Filename(envkeyParam string) string {
+	sum := sha256.Sum256([]byte(envkeyParam))
+	return hex.EncodeToString(sum[:])
+}
+
+// seal sets the entry's version and an HMAC keyed from pw.
+func (entry *Entry) seal(pw []byte) {
+	entry.Version = FormatVersion
+	entry.Hmac = hex.EncodeToString(entry.mac(pw))
+}
+
+// verify checks the entry's version and its HMAC against pw.
+func (entry *Entry) verify(pw []byte) error {
+	if entry.Version != FormatVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, entry.Version)
+	}
+
+	mac, err := hex.DecodeString(entry.Hmac)
+	if err != nil || !hmac.Equal(mac, entry.mac(pw)) {
+		return ErrIntegrity
+	}
+
+	return nil
+}
+
+func (entry *Entry) info(name string, size int64) *EntryInfo {
+	return &EntryInfo{
+		Name:      name,
+		Size:      size,
+		Version:   entry.Version,
+		FetchedAt: entry.FetchedAt,
+		SourceUrl: entry.SourceUrl,
+		ETag:      entry.ETag,
+	}
+}
+
+// mac covers every field but Hmac. Fields are length-prefixed so they can't be shifted into one another.
+func (entry *Entry) mac(pw []byte) []byte {
+	key := macKey(pw)
+	defer secmem.Zero(key)
+
+	mac := hmac.New(sha256.New, key)
+	for _, field := range []string{
+		strconv.Itoa(entry.Version),
+		entry.FetchedAt.UTC().Format(time.RFC3339Nano),
+		entry.SourceUrl,
+		entry.ETag,
+		string(entry.Body),
+	} {
+		writeField(mac, field)
+	}
+	return mac.Sum(nil)
+}
+
+// macKey derives a key from pw that's only used for cache integrity.
+func macKey(pw []byte) []byte {
+	h := hmac.New(sha256.New, pw)
+	h.Write([]byte("envkey-fetch cache"))
+	return h.Sum(nil)
+}
+
+func writeField(h hash.Hash, field string) {
+	binary.Write(h, binary.BigEndian, uint64(len(field)))
+	h.Write([]byte(field))
+}
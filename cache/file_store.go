@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// earlier generations are suffixed with a timestamp
+	filenameRegexp = regexp.MustCompile(`^[0-9a-f]{64}(\.[0-9]+|\.versions)?$`)
+	// legacy files are named after the ENVKEY identifier
+	legacyFilenameRegexp = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+)
+
+// FileStore keeps each entry in its own file in Dir. Files are replaced atomically (temp file + rename) while holding an advisory lock, so concurrent writers, including other processes, can't leave a partial file.
+// Dir and the files in it must not be symlinks or writable by other users; see ErrInsecure.
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+func (store *FileStore) Get(name string) (*Entry, error) {
+	err := checkDir(store.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b, _, err := readNoFollow(filepath.Join(store.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %v", ErrNoEntry, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := new(Entry)
+	err = json.Unmarshal(b, entry)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntegrity, err)
+	}
+	return entry, nil
+}
+
+func (store *FileStore) Put(name string, entry *Entry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// ensure dir exists
+	err = os.MkdirAll(store.Dir, 0700)
+	if err != nil {
+		return err
+	}
+	err = checkDir(store.Dir)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockPath(filepath.Join(store.Dir, "."+lockName(name)+".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock(lock)
+
+	return writeAtomic(filepath.Join(store.Dir, name), body)
+}
+
+// Delete securely removes the entry. A legacy entry is found by its hashed name.
+func (store *FileStore) Delete(name string) error {
+	err := store.delete(name)
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	infos, listErr := store.List()
+	if listErr != nil {
+		return listErr
+	}
+	for _, info := range infos {
+		if info.Legacy && info.Name == name {
+			return secureRemove(info.Path)
+		}
+	}
+
+	return fmt.Errorf("%w: %v", ErrNoEntry, err)
+}
+
+func (store *FileStore) delete(name string) error {
+	err := checkDir(store.Dir)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockPath(filepath.Join(store.Dir, "."+lockName(name)+".lock"))
+	if os.IsNotExist(err) {
+		// no dir
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock(lock)
+
+	return secureRemove(filepath.Join(store.Dir, name))
+}
+
+// List describes every entry in Dir. Legacy entries are listed under the name they'll be migrated to, so identifiers aren't revealed.
+func (store *FileStore) List() ([]*EntryInfo, error) {
+	err := checkDir(store.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(store.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []*EntryInfo{}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(store.Dir, file.Name())
+
+		if filenameRegexp.MatchString(file.Name()) {
+			// an unreadable envelope is still listed, so it can be inspected and removed
+			entry, _ := store.Get(file.Name())
+			if entry == nil {
+				entry = new(Entry)
+			}
+
+			info := entry.info(file.Name(), file.Size())
+			info.Path = path
+			infos = append(infos, info)
+
+		} else if legacyFilenameRegexp.MatchString(file.Name()) {
+			infos = append(infos, &EntryInfo{
+				Name:      Filename(file.Name()),
+				Path:      path,
+				Size:      file.Size(),
+				FetchedAt: file.ModTime(),
+				Legacy:    true,
+			})
+		}
+	}
+
+	sortInfos(infos)
+	return infos, nil
+}
+
+// clean securely removes lock and temp files.
+func (store *FileStore) clean() error {
+	err := checkDir(store.Dir)
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(store.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, ".") && (strings.HasSuffix(name, ".lock") || strings.Contains(name, ".tmp-")) {
+			err = secureRemove(filepath.Join(store.Dir, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (store *FileStore) getLegacy(envkeyParam string) (*Entry, error) {
+	err := checkDir(store.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	body, info, err := readNoFollow(filepath.Join(store.Dir, envkeyParam))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %v", ErrNoEntry, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{FetchedAt: info.ModTime(), Body: body}, nil
+}
+
+func (store *FileStore) deleteLegacy(envkeyParam string) error {
+	return secureRemove(filepath.Join(store.Dir, envkeyParam))
+}
+
+// lockName is shared by every generation of an entry, so pruning generations doesn't leave lock files behind.
+func lockName(name string) string {
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+func lockPath(path string) (*os.File, error) {
+	f, err := openNoFollow(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func unlock(f *os.File) {
+	unlockFile(f)
+	f.Close()
+}
+
+// writeAtomic writes to a temp file in the same dir, then renames it over path.
+func writeAtomic(path string, body []byte) error {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp-")
+	if err != nil {
+		return err
+	}
+	// no-op once renamed
+	defer os.Remove(tmp.Name())
+
+	err = tmp.Chmod(0600)
+	if err == nil {
+		_, err = tmp.Write(body)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// secureRemove overwrites a file with zeros before removing it. This is best effort: journaling and copy-on-write filesystems or SSDs may keep old blocks around.
+func secureRemove(path string) error {
+	f, err := openNoFollow(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err == nil {
+		_, err = f.Write(make([]byte, info.Size()))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultGenerations is how many generations of each entry are kept, counting the current one.
+const DefaultGenerations = 5
+
+// historyName is where a previous generation of the entry stored under name is kept.
+func historyName(name string, fetchedAt time.Time) string {
+	return name + "." + strconv.FormatInt(fetchedAt.UnixNano(), 10)
+}
+
+// isHistoryOf is true if other is an earlier generation of the entry stored under name.
+func isHistoryOf(name, other string) bool {
+	suffix := strings.TrimPrefix(other, name+".")
+	if suffix == other || suffix == "" {
+		return false
+	}
+	_, err := strconv.ParseInt(suffix, 10, 64)
+	return err == nil
+}
+
+// isCurrentName is false for earlier generations and version logs, which are stored alongside current entries.
+func isCurrentName(name string) bool {
+	return !strings.Contains(name, ".")
+}
+
+func (cache *Cache) generations() int {
+	if cache.Generations > 0 {
+		return cache.Generations
+	}
+	return DefaultGenerations
+}
+
+// History returns the generations of the entry for envkeyParam that pass verification, newest first. The current entry is generation 0.
+func (cache *Cache) History(envkeyParam string, pw []byte) ([]*Entry, error) {
+	entries := []*Entry{}
+
+	current, err := cache.Read(envkeyParam, pw)
+	if err == nil {
+		entries = append(entries, current)
+	} else if !errors.Is(err, ErrNoEntry) {
+		return nil, err
+	}
+
+	infos, err := cache.historyInfos(Filename(envkeyParam))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		entry, err := cache.store.Get(info.Name)
+		if err != nil || entry.verify(pw) != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		return nil, ErrNoEntry
+	}
+
+	sortEntries(entries)
+	return entries, nil
+}
+
+// ReadGeneration loads and verifies generation n of the entry for envkeyParam, where 0 is the current entry and 1 the one before it.
+func (cache *Cache) ReadGeneration(envkeyParam string, pw []byte, n int) (*Entry, error) {
+	if n == 0 {
+		return cache.Read(envkeyParam, pw)
+	}
+
+	entries, err := cache.History(envkeyParam, pw)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 || n >= len(entries) {
+		return nil, fmt.Errorf("%w: generation %d requested, %d cached", ErrNoEntry, n, len(entries))
+	}
+	return entries[n], nil
+}
+
+// keepPrevious moves the current entry stored under name into history if it verifies and entry replaces it with a different body.
+func (cache *Cache) keepPrevious(name string, pw []byte, entry *Entry) error {
+	current, err := cache.store.Get(name)
+	if err != nil || current.verify(pw) != nil || string(current.Body) == string(entry.Body) {
+		return nil
+	}
+
+	// sealed entries don't depend on their name, so the current one can be stored as is
+	return cache.store.Put(historyName(name, current.FetchedAt), current)
+}
+
+// pruneHistory removes generations beyond the number kept.
+func (cache *Cache) pruneHistory(name string) error {
+	infos, err := cache.historyInfos(name)
+	if err != nil {
+		return err
+	}
+
+	for i, info := range infos {
+		if i < cache.generations()-1 {
+			continue
+		}
+
+		// may have been pruned by another writer already
+		err = cache.store.Delete(info.Name)
+		if err != nil && !errors.Is(err, ErrNoEntry) {
+			return err
+		}
+	}
+	return nil
+}
+
+// historyInfos describes previous generations of the entry stored under name, newest first.
+func (cache *Cache) historyInfos(name string) ([]*EntryInfo, error) {
+	infos, err := cache.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	history := []*EntryInfo{}
+	for _, info := range infos {
+		if isHistoryOf(name, info.Name) {
+			history = append(history, info)
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// EntryInfo describes a stored entry. It's read without checking the HMAC, since that takes the passphrase, so it shouldn't be trusted beyond display.
+type EntryInfo struct {
+	Name      string
+	Path      string // only set by FileStore
+	Size      int64
+	Version   int
+	FetchedAt time.Time
+	SourceUrl string
+	ETag      string
+	Legacy    bool
+}
+
+func (info *EntryInfo) Age() time.Duration {
+	return time.Since(info.FetchedAt)
+}
+
+// List describes every current entry, newest first. Earlier generations and version logs aren't included.
+func (cache *Cache) List() ([]*EntryInfo, error) {
+	infos, err := cache.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	current := []*EntryInfo{}
+	for _, info := range infos {
+		if isCurrentName(info.Name) {
+			current = append(current, info)
+		}
+	}
+	return current, nil
+}
+
+// Find returns the entry whose name starts with prefix. It fails if there's no match or more than one.
+func (cache *Cache) Find(prefix string) (*EntryInfo, error) {
+	infos, err := cache.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *EntryInfo
+	for _, info := range infos {
+		if prefix != "" && strings.HasPrefix(info.Name, prefix) {
+			if found != nil {
+				return nil, errors.New("more than one cache entry starts with " + prefix)
+			}
+			found = info
+		}
+	}
+
+	if found == nil {
+		return nil, ErrNoEntry
+	}
+	return found, nil
+}
+
+// Remove removes the entry described by info. A FileStore overwrites it first.
+func (cache *Cache) Remove(info *EntryInfo) error {
+	return cache.store.Delete(info.Name)
+}
+
+// Prune removes entries, generations and version logs last written longer than maxAge ago and returns them.
+func (cache *Cache) Prune(maxAge time.Duration) ([]*EntryInfo, error) {
+	infos, err := cache.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []*EntryInfo{}
+	for _, info := range infos {
+		if info.Age() <= maxAge {
+			continue
+		}
+
+		err = cache.Remove(info)
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, info)
+	}
+
+	return pruned, nil
+}
+
+// Clear removes every entry, generation and version log. For a FileStore, lock and temp files are removed too, so it shouldn't be run while another process is using the cache.
+func (cache *Cache) Clear() (int, error) {
+	infos, err := cache.store.List()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, info := range infos {
+		err = cache.Remove(info)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	if fileStore, ok := cache.store.(*FileStore); ok {
+		err = fileStore.clean()
+	}
+
+	return len(infos), err
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+var ErrInsecure = errors.New("insecure cache path")
+
+// checkDir refuses a cache directory that's a symlink, or that another user could write to. A missing directory is fine, since it'll be created with mode 0700.
+func checkDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return symlinkError(dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cache directory %s isn't a directory", dir)
+	}
+	return checkPermissions(dir, info)
+}
+
+// openNoFollow opens a file in the cache without following a symlink, so one can't be swapped in to redirect reads or writes, then checks it like checkDir.
+func openNoFollow(path string, flag int, perm os.FileMode) (*os.File, error) {
+	if noFollow == 0 {
+		// without O_NOFOLLOW this is racy, but a planted symlink is still caught
+		info, err := os.Lstat(path)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return nil, symlinkError(path)
+		}
+	}
+
+	f, err := os.OpenFile(path, flag|noFollow, perm)
+	if isSymlinkErr(err) {
+		return nil, symlinkError(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = fmt.Errorf("%w: %s isn't a regular file", ErrInsecure, path)
+	}
+	if err == nil {
+		err = checkPermissions(path, info)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// readNoFollow reads a file with openNoFollow.
+func readNoFollow(path string) ([]byte, os.FileInfo, error) {
+	f, err := openNoFollow(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, info, nil
+}
+
+func symlinkError(path string) error {
+	return fmt.Errorf("%w: %s is a symlink, which another user could swap to redirect the cache; point --cache-dir at the real directory instead", ErrInsecure, path)
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package cache
+
+import "os"
+
+// No O_NOFOLLOW on this platform; openNoFollow checks with Lstat instead.
+const noFollow = 0
+
+func isSymlinkErr(err error) bool {
+	return false
+}
+
+// Unix permission bits and owners don't apply here; on Windows, the cache relies on the ACLs of the user's profile directory.
+func checkPermissions(path string, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const noFollow = syscall.O_NOFOLLOW
+
+// O_NOFOLLOW fails with ELOOP, or EMLINK on FreeBSD, when the path is a symlink.
+func isSymlinkErr(err error) bool {
+	return errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.EMLINK)
+}
+
+// checkPermissions refuses paths that are writable by group or others, or owned by anyone but the current user or root. Root-owned paths are allowed so a cache can be baked into an image.
+func checkPermissions(path string, info os.FileInfo) error {
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%w: %s is writable by its group or other users (mode %s); fix with: chmod go-w %s", ErrInsecure, path, info.Mode().Perm(), path)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if uid := int(stat.Uid); uid != os.Getuid() && uid != 0 {
+		return fmt.Errorf("%w: %s is owned by uid %d, not the current user (uid %d)", ErrInsecure, path, uid, os.Getuid())
+	}
+
+	return nil
+}
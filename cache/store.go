@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrNoEntry = errors.New("no matching cache entry")
+
+// Store keeps sealed entries by name. Implementations must be safe for concurrent use. Get and Delete return an error matching ErrNoEntry for a missing entry.
+type Store interface {
+	Get(name string) (*Entry, error)
+	Put(name string, entry *Entry) error
+	Delete(name string) error
+	List() ([]*EntryInfo, error)
+}
+
+// legacyStore is implemented by stores that may hold entries from before entries were sealed and named by hash.
+type legacyStore interface {
+	getLegacy(envkeyParam string) (*Entry, error)
+	deleteLegacy(envkeyParam string) error
+}
+
+// MemoryStore keeps entries for the life of the process. It's meant for long-running services and tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]Entry{}}
+}
+
+func (store *MemoryStore) Get(name string) (*Entry, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.entries[name]
+	if !ok {
+		return nil, ErrNoEntry
+	}
+	return &entry, nil
+}
+
+func (store *MemoryStore) Put(name string, entry *Entry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored := *entry
+	stored.Body = append([]byte(nil), entry.Body...)
+	store.entries[name] = stored
+	return nil
+}
+
+func (store *MemoryStore) Delete(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.entries[name]; !ok {
+		return ErrNoEntry
+	}
+	delete(store.entries, name)
+	return nil
+}
+
+func (store *MemoryStore) List() ([]*EntryInfo, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	infos := []*EntryInfo{}
+	for name, entry := range store.entries {
+		infos = append(infos, entry.info(name, int64(len(entry.Body))))
+	}
+	sortInfos(infos)
+	return infos, nil
+}
+
+// sortInfos sorts newest first.
+func sortInfos(infos []*EntryInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].FetchedAt.After(infos[j].FetchedAt)
+	})
+}
+
+func sortEntries(entries []*Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].FetchedAt.After(entries[j].FetchedAt)
+	})
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxVersions bounds the version log. Replays of payloads older than this many versions aren't detected.
+const maxVersions = 100
+
+var ErrReplay = errors.New("previously seen payload replayed")
+
+// Version is a payload seen for an ENVKEY. Chain hashes the previous version's Chain along with Hash and FirstSeen, so versions can't be reordered or removed from the middle of the log.
+type Version struct {
+	Hash      string    `json:"hash"`
+	FirstSeen time.Time `json:"first_seen"`
+	Chain     string    `json:"chain"`
+}
+
+// ReplayError is returned by CheckVersion when a payload that was already replaced by a newer one comes back. It matches ErrReplay.
+type ReplayError struct {
+	FirstSeen time.Time
+	NewerSeen time.Time
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("%s: first seen %s, replaced by a newer payload first seen %s", ErrReplay.Error(), e.FirstSeen.Format(time.RFC3339), e.NewerSeen.Format(time.RFC3339))
+}
+
+func (e *ReplayError) Is(target error) bool {
+	return target == ErrReplay
+}
+
+// versionsMu serializes updates to version logs within the process, for stores that aren't kept on disk. lockVersions also locks between processes.
+var versionsMu sync.Mutex
+
+// versionsName is where the version log for the entry stored under name is kept. It's sealed like an entry, with the log as its body.
+func versionsName(name string) string {
+	return name + ".versions"
+}
+
+// CheckVersion records payload as the latest version seen for envkeyParam, unless it was seen before and a newer version has been seen since, in which case it returns a *ReplayError and the log is left as is.
+// The log is sealed with an HMAC keyed from pw, so it fails with ErrIntegrity if it's been modified.
+func (cache *Cache) CheckVersion(envkeyParam string, pw []byte, payload []byte) error {
+	name := versionsName(Filename(envkeyParam))
+
+	// held from reading the log until it's written back, so concurrent fetches don't drop each other's versions
+	unlock, err := cache.lockVersions(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	versions, err := cache.readVersions(name, pw)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(payload)
+	hash := hex.EncodeToString(sum[:])
+
+	for i, version := range versions {
+		if version.Hash != hash {
+			continue
+		}
+		if i == len(versions)-1 {
+			// same as the latest
+			return nil
+		}
+		return &ReplayError{FirstSeen: version.FirstSeen, NewerSeen: versions[len(versions)-1].FirstSeen}
+	}
+
+	var prevChain string
+	if len(versions) > 0 {
+		prevChain = versions[len(versions)-1].Chain
+	}
+	version := Version{Hash: hash, FirstSeen: time.Now().UTC()}
+	version.Chain = chainHash(prevChain, version)
+
+	versions = append(versions, version)
+	if len(versions) > maxVersions {
+		versions = versions[len(versions)-maxVersions:]
+	}
+
+	body, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+
+	entry := &Entry{FetchedAt: version.FirstSeen, Body: body}
+	entry.seal(pw)
+	return cache.store.Put(name, entry)
+}
+
+// lockVersions locks the version log stored under name. Stores kept on disk are also locked between processes, with a lock file separate from the one the store takes for each Put.
+func (cache *Cache) lockVersions(name string) (func(), error) {
+	versionsMu.Lock()
+
+	store, ok := cache.store.(lockDirStore)
+	if !ok {
+		return versionsMu.Unlock, nil
+	}
+
+	err := os.MkdirAll(store.lockDir(), 0700)
+	if err == nil {
+		err = checkDir(store.lockDir())
+	}
+	var f *os.File
+	if err == nil {
+		f, err = lockPath(filepath.Join(store.lockDir(), "."+name+".lock"))
+	}
+	if err != nil {
+		versionsMu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlock(f)
+		versionsMu.Unlock()
+	}, nil
+}
+
+// Versions returns the version log for envkeyParam, oldest first.
+func (cache *Cache) Versions(envkeyParam string, pw []byte) ([]Version, error) {
+	return cache.readVersions(versionsName(Filename(envkeyParam)), pw)
+}
+
+// readVersions returns an empty log if there isn't one yet.
+func (cache *Cache) readVersions(name string, pw []byte) ([]Version, error) {
+	entry, err := cache.store.Get(name)
+	if errors.Is(err, ErrNoEntry) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = entry.verify(pw)
+	if err != nil {
+		return nil, fmt.Errorf("version log: %w", err)
+	}
+
+	var versions []Version
+	err = json.Unmarshal(entry.Body, &versions)
+	if err != nil {
+		return nil, fmt.Errorf("version log: %w: %v", ErrIntegrity, err)
+	}
+
+	// the first version's predecessor may have been trimmed, so its chain is taken as is
+	for i := 1; i < len(versions); i++ {
+		if versions[i].Chain != chainHash(versions[i-1].Chain, versions[i]) {
+			return nil, fmt.Errorf("version log: %w: broken chain", ErrIntegrity)
+		}
+	}
+
+	return versions, nil
+}
+
+func chainHash(prevChain string, version Version) string {
+	h := sha256.New()
+	for _, field := range []string{prevChain, version.Hash, strconv.FormatInt(version.FirstSeen.UnixNano(), 10)} {
+		writeField(h, field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
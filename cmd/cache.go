@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/secmem"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+var showSource bool
+var olderThan time.Duration
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage cached encrypted config. Entries are identified by a hash of their ENVKEY's identifier.",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cache entries with their size and age.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := openCache()
+		infos, err := c.List()
+		exitIfErr(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		header := "ID\tSIZE\tAGE\tFORMAT"
+		if showSource {
+			header += "\tSOURCE"
+		}
+		fmt.Fprintln(w, header)
+
+		for _, info := range infos {
+			row := strings.Join([]string{shortName(info), strconv.FormatInt(info.Size, 10), formatAge(info), formatVersion(info)}, "\t")
+			if showSource {
+				row += "\t" + info.SourceUrl
+			}
+			fmt.Fprintln(w, row)
+		}
+		w.Flush()
+	},
+}
+
+var cacheInspectCmd = &cobra.Command{
+	Use:   "inspect ID",
+	Short: "Show a cache entry's metadata. ID can be any unique prefix of the id shown by list.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := openCache()
+		info, err := c.Find(args[0])
+		exitIfErr(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "id:\t%s\n", info.Name)
+		fmt.Fprintf(w, "format:\t%s\n", formatVersion(info))
+		fmt.Fprintf(w, "size:\t%d\n", info.Size)
+		if !info.FetchedAt.IsZero() {
+			fmt.Fprintf(w, "fetched at:\t%s\n", info.FetchedAt.Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "age:\t%s\n", formatAge(info))
+		if info.ETag != "" {
+			fmt.Fprintf(w, "etag:\t%s\n", info.ETag)
+		}
+		if showSource && info.SourceUrl != "" {
+			fmt.Fprintf(w, "source:\t%s\n", info.SourceUrl)
+		}
+		w.Flush()
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify [ENVKEY]",
+	Short: "Check a cache entry's integrity, then verify and decrypt it. Uses $ENVKEY if no ENVKEY is given. Values are never printed.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		envkey := os.Getenv("ENVKEY")
+		if len(args) > 0 {
+			envkey = args[0]
+		}
+
+		split := strings.SplitN(envkey, "-", 3)
+		if len(split) < 2 {
+			exitIfErr(errors.New("ENVKEY invalid"))
+		}
+		envkeyParam, pw := split[0], []byte(split[1])
+		defer secmem.Zero(pw)
+
+		c := openCache()
+		entry, err := c.Read(envkeyParam, pw)
+		exitIfErr(err)
+
+		options := fetchOptions()
+		response, err := fetch.DecodeResponse(entry.Body, options)
+		exitIfErr(err)
+
+		trustPolicy, err := fetch.LoadTrustPolicy(envkey, options)
+		exitIfErr(err)
+
+		verifiedEnv, err := response.ParseVerifiedWithPolicy(pw, trustPolicy)
+		exitIfErr(err)
+
+		var env map[string]interface{}
+		exitIfErr(json.Unmarshal([]byte(verifiedEnv.Json), &env))
+
+		fmt.Printf("ok: %s fetched %s ago, signed by %s, %d vars\n", cache.Filename(envkeyParam)[:12], time.Since(entry.FetchedAt).Round(time.Second), verifiedEnv.SignerId, len(env))
+	},
+}
+
+var cacheHistoryCmd = &cobra.Command{
+	Use:   "history [ENVKEY]",
+	Short: "List cached generations, newest first, with the keys added (+), removed (-) and changed (~) since the one before. Uses $ENVKEY if no ENVKEY is given. Values are never printed.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		envkey := os.Getenv("ENVKEY")
+		if len(args) > 0 {
+			envkey = args[0]
+		}
+
+		generations, err := fetch.History(envkey, fetchOptions())
+		exitIfErr(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "GEN\tFETCHED AT\tSIGNED BY\tKEYS\tCHANGES")
+
+		for i, generation := range generations {
+			var keys, changes string
+			if generation.Err != nil {
+				keys, changes = "-", "invalid: "+generation.Err.Error()
+			} else {
+				keys, changes = strconv.Itoa(len(generation.Keys)), formatChanges(generation, i == len(generations)-1)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i, generation.FetchedAt.Format(time.RFC3339), generation.SignerId, keys, changes)
+		}
+		w.Flush()
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune --older-than DURATION",
+	Short: "Remove cache entries fetched longer ago than --older-than.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if olderThan <= 0 {
+			exitIfErr(errors.New("--older-than is required"))
+		}
+
+		c := openCache()
+		pruned, err := c.Prune(olderThan)
+		for _, info := range pruned {
+			fmt.Printf("removed %s (%s old)\n", shortName(info), formatAge(info))
+		}
+		exitIfErr(err)
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove every cache entry.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := openCache()
+		n, err := c.Clear()
+		fmt.Printf("removed %d entries\n", n)
+		exitIfErr(err)
+	},
+}
+
+func openCache() *cache.Cache {
+	if store := cacheStore(); store != nil {
+		return cache.NewCacheWithStore(store)
+	}
+
+	c, err := cache.NewCache(cacheDir)
+	exitIfErr(err)
+	return c
+}
+
+// cacheStore is nil for the default file store, which is set up from --cache-dir.
+func cacheStore() cache.Store {
+	switch cacheStoreName {
+	case "", "file":
+		return nil
+	case "bundle":
+		path := cacheBundle
+		if path == "" {
+			path = filepath.Join("~", ".envkey", "cache.bundle")
+		}
+		path, err := homedir.Expand(path)
+		exitIfErr(err)
+		return cache.NewBundleStore(path)
+	default:
+		exitIfErr(errors.New("unknown cache store: " + cacheStoreName))
+		return nil
+	}
+}
+
+func formatChanges(generation *fetch.Generation, oldest bool) string {
+	if oldest {
+		return "(oldest)"
+	}
+
+	changes := []string{}
+	for _, key := range generation.Added {
+		changes = append(changes, "+"+key)
+	}
+	for _, key := range generation.Removed {
+		changes = append(changes, "-"+key)
+	}
+	for _, key := range generation.Changed {
+		changes = append(changes, "~"+key)
+	}
+
+	if len(changes) == 0 {
+		return "(none)"
+	}
+	return strings.Join(changes, " ")
+}
+
+func exitIfErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func shortName(info *cache.EntryInfo) string {
+	return info.Name[:12]
+}
+
+func formatAge(info *cache.EntryInfo) string {
+	if info.FetchedAt.IsZero() {
+		return "unknown"
+	}
+	return info.Age().Round(time.Second).String()
+}
+
+func formatVersion(info *cache.EntryInfo) string {
+	if info.Legacy {
+		return "legacy"
+	}
+	return "v" + strconv.Itoa(info.Version)
+}
+
+func init() {
+	cacheListCmd.Flags().BoolVar(&showSource, "show-source", false, "show the url each entry was fetched from, which includes its ENVKEY identifier (default is false)")
+	cacheInspectCmd.Flags().BoolVar(&showSource, "show-source", false, "show the url the entry was fetched from, which includes its ENVKEY identifier (default is false)")
+	cachePruneCmd.Flags().DurationVar(&olderThan, "older-than", 0, "remove entries fetched longer ago than this")
+
+	cacheCmd.AddCommand(cacheListCmd, cacheInspectCmd, cacheVerifyCmd, cacheHistoryCmd, cachePruneCmd, cacheClearCmd)
+	RootCmd.AddCommand(cacheCmd)
+}
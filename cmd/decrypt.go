@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/envkey/envkey-fetch/fetch"
+
+	"github.com/spf13/cobra"
+)
+
+var responseFile string
+var verifyOnly bool
+var decryptFormat string
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt [ENVKEY or passphrase]",
+	Short: "Verify and decrypt a raw response saved from the server, read from --response or stdin, without using the network or the cache. Prints config as json like envkey-fetch does. Uses $ENVKEY if no ENVKEY or passphrase is given.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := os.Getenv("ENVKEY")
+		if len(args) > 0 {
+			key = args[0]
+		}
+
+		body, source, err := readResponseFile(responseFile)
+		exitIfErr(err)
+
+		if verifyOnly {
+			explanation, err := fetch.ExplainResponse(body, key, fetchOptions())
+			exitIfErr(err)
+
+			format := decryptFormat
+			if format == "" {
+				format = "text"
+			}
+			printExplanationFormat(format, explanation, source, nil)
+			if !explanation.Verified {
+				os.Exit(1)
+			}
+			return
+		}
+
+		// check the format first, so nothing is decrypted for a report-only format
+		format := decryptFormat
+		if format == "" {
+			format = "json"
+		}
+		switch format {
+		case "json", "env":
+		case "text", "dot":
+			exitIfErr(errors.New("format " + format + " only applies to --verify-only, config is printed as json or env"))
+		default:
+			exitIfErr(errors.New("unknown format: " + format))
+		}
+
+		verifiedEnv, err := fetch.DecryptResponse(body, key, fetchOptions())
+		exitIfErr(err)
+		printConfig(format, verifiedEnv.Json)
+	},
+}
+
+// printConfig prints config as json, like a fetch, or as env: KEY=value lines sorted by key. Values that a shell or dotenv parser would split or expand are double quoted with Go escapes.
+func printConfig(format, configJson string) {
+	if format == "json" {
+		fmt.Println(configJson)
+		return
+	}
+
+	var env map[string]interface{}
+	exitIfErr(json.Unmarshal([]byte(configJson), &env))
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := env[key].(string)
+		if !ok {
+			out, err := json.Marshal(env[key])
+			exitIfErr(err)
+			value = string(out)
+		}
+		if strings.ContainsAny(value, " \t\r\n\"'\\$`#") {
+			value = strconv.Quote(value)
+		}
+		fmt.Printf("%s=%s\n", key, value)
+	}
+}
+
+// readResponseFile reads path, or stdin if path is "" or "-", and returns a description of where it read from.
+func readResponseFile(path string) ([]byte, string, error) {
+	if path == "" || path == "-" {
+		body, err := ioutil.ReadAll(os.Stdin)
+		return body, "stdin", err
+	}
+
+	body, err := ioutil.ReadFile(path)
+	return body, path, err
+}
+
+func init() {
+	decryptCmd.Flags().StringVar(&responseFile, "response", "", "file containing the raw json response, or - for stdin (default is stdin)")
+	decryptCmd.Flags().BoolVar(&verifyOnly, "verify-only", false, "check signatures and the trust chain and print a report instead of the config, exiting with 1 if it doesn't verify (default is false)")
+	decryptCmd.Flags().StringVar(&decryptFormat, "format", "", "output format: json or env (KEY=value lines) for config, text, json or dot (Graphviz) for the --verify-only report (default is json for config, text for the report)")
+
+	RootCmd.AddCommand(decryptCmd)
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/parser"
+	"github.com/envkey/envkey-fetch/trust"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var explainFormat string
+
+var explainCmd = &cobra.Command{
+	Use:   "explain [ENVKEY]",
+	Short: "Fetch config like envkey-fetch does, then show each verification step and the trust chain from each signer back to a trusted root, instead of the config. Uses $ENVKEY if no ENVKEY is given. Values are never printed.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		envkey := os.Getenv("ENVKEY")
+		if len(args) > 0 {
+			envkey = args[0]
+		}
+
+		explanation, result, err := fetch.Explain(envkey, fetchOptions())
+		exitIfErr(err)
+
+		printExplanationFormat(explainFormat, explanation, result.Source, &result.FetchedAt)
+		if !explanation.Verified {
+			os.Exit(1)
+		}
+	},
+}
+
+// printExplanationFormat prints explanation as text, json or dot. source is where the response was loaded from, and fetchedAt is when, if that's known.
+func printExplanationFormat(format string, explanation *parser.Explanation, source string, fetchedAt *time.Time) {
+	switch format {
+	case "text":
+		printExplanation(explanation, source, fetchedAt)
+	case "json":
+		out, err := json.MarshalIndent(struct {
+			Source    string     `json:"source"`
+			FetchedAt *time.Time `json:"fetched_at,omitempty"`
+			*parser.Explanation
+		}{source, fetchedAt, explanation}, "", "  ")
+		exitIfErr(err)
+		fmt.Println(string(out))
+	case "dot":
+		printExplanationDot(explanation)
+	default:
+		exitIfErr(errors.New("unknown format: " + format))
+	}
+}
+
+func printExplanation(explanation *parser.Explanation, source string, fetchedAt *time.Time) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if fetchedAt != nil {
+		fmt.Fprintf(w, "source:\t%s, fetched at %s\n", source, fetchedAt.Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(w, "source:\t%s\n", source)
+	}
+	fmt.Fprintln(w, "steps:")
+	for _, step := range explanation.Steps {
+		fmt.Fprintf(w, "  %s\t%s\n", step.Name, formatResult(step.Error))
+	}
+	w.Flush()
+
+	for _, signer := range explanation.Signers {
+		fmt.Println()
+		printSignerTrust(signer)
+	}
+
+	fmt.Println()
+	if explanation.Verified {
+		fmt.Println("result: verified")
+	} else {
+		fmt.Println("result: not verified")
+	}
+}
+
+func printSignerTrust(signer *trust.SignerTrust) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if signer.IsInheritanceSigner {
+		fmt.Fprintf(w, "inheritance overrides signer:\t%s\n", signer.Id)
+	} else {
+		fmt.Fprintf(w, "signer:\t%s\n", signer.Id)
+	}
+	fmt.Fprintf(w, "  fingerprint:\t%s\n", signer.Fingerprint)
+
+	if signer.ListedIn == "" {
+		fmt.Fprintln(w, "  listed in:\t(not listed)")
+	} else {
+		match := "matches"
+		if signer.TrustedFingerprint != signer.Fingerprint {
+			match = "does not match"
+		}
+		fmt.Fprintf(w, "  listed in:\t%s, fingerprint %s %s\n", signer.ListedIn, signer.TrustedFingerprint, match)
+	}
+	w.Flush()
+
+	if len(signer.Path) > 0 {
+		fmt.Println("  path:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, link := range signer.Path {
+			var invite string
+			if link.Root {
+				invite = "trusted root"
+			} else if link.InvitedById == "" {
+				invite = formatResult(link.Error)
+			} else {
+				invite = "invited by " + link.InvitedById + ": " + formatResult(link.Error)
+			}
+			fmt.Fprintf(w, "    %s\t%s\t%s\n", link.Id, link.Fingerprint, invite)
+		}
+		w.Flush()
+	}
+
+	if signer.Trusted {
+		fmt.Println("  trusted")
+	} else {
+		fmt.Println("  not trusted: " + signer.Error)
+	}
+}
+
+// printExplanationDot draws each signer's path as edges from a key to the key that invited it. Roots are double circles, signers boxes, and failed links red.
+func printExplanationDot(explanation *parser.Explanation) {
+	fmt.Println("digraph trust {")
+	fmt.Println("  rankdir=LR;")
+
+	declared := map[string]bool{}
+	node := func(id, fingerprint, attrs string) {
+		if declared[id] {
+			return
+		}
+		declared[id] = true
+		label := id
+		if fingerprint != "" {
+			label += "\n" + fingerprint
+		}
+		fmt.Printf("  %s [label=%s%s];\n", strconv.Quote(id), strconv.Quote(label), attrs)
+	}
+
+	for _, signer := range explanation.Signers {
+		attrs := ", shape=box"
+		if !signer.Trusted {
+			attrs += ", color=red"
+		}
+		if len(signer.Path) > 0 && signer.Path[0].Root {
+			attrs = ", shape=doublecircle"
+		}
+		node(signer.Id, signer.Fingerprint, attrs)
+
+		for i, link := range signer.Path {
+			if link.Root {
+				node(link.Id, link.Fingerprint, ", shape=doublecircle")
+			} else {
+				node(link.Id, link.Fingerprint, "")
+			}
+
+			if link.Root || link.InvitedById == "" {
+				continue
+			}
+
+			if link.Error == "" {
+				fmt.Printf("  %s -> %s [label=\"ok\"];\n", strconv.Quote(link.Id), strconv.Quote(link.InvitedById))
+				continue
+			}
+
+			// the inviter is only in the path if it was found
+			if i == len(signer.Path)-1 {
+				node(link.InvitedById, "", ", style=dashed")
+			}
+			fmt.Printf("  %s -> %s [label=%s, color=red, style=dashed];\n", strconv.Quote(link.Id), strconv.Quote(link.InvitedById), strconv.Quote("failed: "+link.Error))
+		}
+	}
+
+	fmt.Println("}")
+}
+
+func formatResult(err string) string {
+	if err == "" {
+		return "ok"
+	}
+	return "failed: " + err
+}
+
+// addFetchFlags lets cmd take the same flags as a plain fetch. It's called from root.go's init, once those flags are defined.
+func addFetchFlags(cmd *cobra.Command) {
+	RootCmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name != "version" {
+			cmd.Flags().AddFlag(flag)
+		}
+	})
+}
+
+func init() {
+	explainCmd.Flags().StringVar(&explainFormat, "format", "text", "output format: text, json or dot (Graphviz)")
+
+	RootCmd.AddCommand(explainCmd)
+}
@@ -23,14 +23,19 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/envkey/envkey-fetch/cache"
 	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/secmem"
 	"github.com/envkey/envkey-fetch/version"
 
 	"github.com/spf13/cobra"
 )
 
 var cacheDir string
+var cacheStoreName string
+var cacheBundle string
 var shouldCache bool
 var printVersion bool
 var verboseOutput bool
@@ -39,11 +44,44 @@ var clientVersion string
 var timeoutSeconds float64
 var retries uint8
 var retryBackoff float64
+var pins []string
+var pinReportOnly bool
+var caStrategy string
+var caFile string
+var proxy string
+var proxyCredentialsFile string
+var headers []string
+var metadataHeaders bool
+var resolve []string
+var dnsServer string
+var maxResponseBytes int64
+var strictResponse bool
+var crossCheck bool
+var crossCheckPolicy string
+var preferCache bool
+var cacheMaxAge time.Duration
+var refreshTimeout time.Duration
+var offline bool
+var noBackup bool
+var cacheOnlyOnOutage bool
+var maxStale time.Duration
+var requireFresh bool
+var cacheGenerations int
+var rollback int
+var replayPolicy string
+var coalesce bool
+var coalesceWait time.Duration
+var trustPolicyFile string
+var knownSigners string
+var knownSignersFile string
+var lockMemory bool
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "envkey-fetch YOUR-ENVKEY",
 	Short: "Fetches, decrypts, and verifies EnvKey config. Accepts a single envkey as an argument. Returns decrypted config as json. Can optionally cache encrypted config locally.",
+	// the envkey argument isn't a subcommand
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		if printVersion {
 			fmt.Println(version.Version)
@@ -51,22 +89,79 @@ var RootCmd = &cobra.Command{
 		}
 
 		if len(args) > 0 {
-			res, err := fetch.Fetch(args[0], fetch.FetchOptions{shouldCache, cacheDir, clientName, clientVersion, verboseOutput, timeoutSeconds, retries, retryBackoff})
+			refreshed := make(chan struct{})
+			res, err := fetch.FetchWithRefresh(args[0], fetchOptions(), func(string, error) { close(refreshed) })
 			if err != nil {
 				fmt.Fprintln(os.Stderr, "error: "+err.Error())
 				os.Exit(1)
 			} else {
 				fmt.Println(res)
 			}
+
+			if preferCache {
+				// let readers waiting on EOF go while a background refresh finishes
+				os.Stdout.Close()
+			}
+			<-refreshed
 		} else {
 			cmd.Help()
 		}
 	},
 }
 
+func fetchOptions() fetch.FetchOptions {
+	return fetch.FetchOptions{
+		ShouldCache:          shouldCache,
+		CacheDir:             cacheDir,
+		CacheStore:           cacheStore(),
+		CacheGenerations:     cacheGenerations,
+		ClientName:           clientName,
+		ClientVersion:        clientVersion,
+		VerboseOutput:        verboseOutput,
+		TimeoutSeconds:       timeoutSeconds,
+		Retries:              retries,
+		RetryBackoff:         retryBackoff,
+		Pins:                 pins,
+		PinReportOnly:        pinReportOnly,
+		CAStrategy:           caStrategy,
+		CAFile:               caFile,
+		Proxy:                proxy,
+		ProxyCredentialsFile: proxyCredentialsFile,
+		Headers:              headers,
+		MetadataHeaders:      metadataHeaders,
+		Resolve:              resolve,
+		DNSServer:            dnsServer,
+		MaxBodyBytes:         maxResponseBytes,
+		StrictResponse:       strictResponse,
+		CrossCheck:           crossCheck,
+		CrossCheckPolicy:     crossCheckPolicy,
+		PreferCache:          preferCache,
+		CacheMaxAge:          cacheMaxAge,
+		RefreshTimeout:       refreshTimeout,
+		Offline:              offline,
+		NoBackup:             noBackup,
+		CacheOnlyOnOutage:    cacheOnlyOnOutage,
+		MaxStale:             maxStale,
+		RequireFresh:         requireFresh,
+		Rollback:             rollback,
+		ReplayPolicy:         replayPolicy,
+		Coalesce:             coalesce,
+		CoalesceWait:         coalesceWait,
+		TrustPolicyFile:      trustPolicyFile,
+		KnownSigners:         knownSigners,
+		KnownSignersFile:     knownSignersFile,
+		LockMemory:           lockMemory,
+	}
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	// the passphrase and decrypted config are only ever in memory, so keep them out of core dumps
+	if err := secmem.DisableCoreDumps(); err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: "+err.Error())
+	}
+
 	if err := RootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -75,7 +170,23 @@ func Execute() {
 
 func init() {
 	RootCmd.Flags().BoolVar(&shouldCache, "cache", false, "cache encrypted config as a local backup (default is false)")
-	RootCmd.Flags().StringVar(&cacheDir, "cache-dir", "", "cache directory (default is $HOME/.envkey/cache)")
+	RootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "", "cache directory (default is $ENVKEY_CACHE_DIR, $XDG_CACHE_HOME/envkey, or $HOME/.envkey/cache)")
+	RootCmd.PersistentFlags().StringVar(&cacheStoreName, "cache-store", "file", "where to keep the cache: file (a file per ENVKEY in --cache-dir) or bundle (every ENVKEY in the --cache-bundle file)")
+	RootCmd.PersistentFlags().StringVar(&trustPolicyFile, "trust-policy", "", "trust policy file pinning root key fingerprints and allowing or denying signers (default is $HOME/.envkey/trust_policy.json if it exists)")
+	RootCmd.PersistentFlags().StringVar(&cacheBundle, "cache-bundle", "", "cache bundle file for --cache-store bundle (default is $HOME/.envkey/cache.bundle)")
+	RootCmd.Flags().BoolVar(&preferCache, "prefer-cache", false, "return cached config right away if it's younger than --cache-max-age, then refresh the cache (implies --cache, default is false)")
+	RootCmd.Flags().DurationVar(&cacheMaxAge, "cache-max-age", fetch.DefaultCacheMaxAge, "maximum age of cached config used by --prefer-cache")
+	RootCmd.Flags().BoolVar(&offline, "offline", false, "only load from the cache, never the network (implies --cache, default is false)")
+	RootCmd.Flags().BoolVar(&noBackup, "no-backup", false, "never load from the s3 backup urls (default is false)")
+	RootCmd.Flags().BoolVar(&cacheOnlyOnOutage, "cache-only-on-outage", false, "only fall back to the cache when servers are unreachable or failing, not when they refuse a request (default is false)")
+	RootCmd.Flags().DurationVar(&maxStale, "max-stale", 0, "refuse cached config older than this (default is no limit)")
+	RootCmd.Flags().BoolVar(&requireFresh, "require-fresh", false, "never fall back to cached config (default is false)")
+	RootCmd.Flags().IntVar(&cacheGenerations, "cache-generations", cache.DefaultGenerations, "number of verified generations of each ENVKEY's config to keep in the cache, counting the latest")
+	RootCmd.Flags().IntVar(&rollback, "rollback", 0, "load the Nth previous cached generation instead of fetching, e.g. 1 for the one before the latest (implies --cache, default is 0)")
+	RootCmd.Flags().DurationVar(&refreshTimeout, "refresh-timeout", 0, "time limit for the background refresh with --prefer-cache (default is --timeout)")
+	RootCmd.Flags().BoolVar(&coalesce, "coalesce", false, "let one process at a time fetch each ENVKEY, while others on the host wait and use what it cached (implies --cache, default is false)")
+	RootCmd.Flags().DurationVar(&coalesceWait, "coalesce-wait", fetch.DefaultCoalesceWait, "how long --coalesce waits for another process's fetch, and how recently it must have fetched")
+	RootCmd.Flags().BoolVar(&lockMemory, "mlock", false, "lock the ENVKEY passphrase into memory while it's in use so it can't be swapped to disk, for when --prefer-cache or --coalesce keep the process running (linux only, default is false)")
 	RootCmd.Flags().StringVar(&clientName, "client-name", "", "calling client library name (default is none)")
 	RootCmd.Flags().StringVar(&clientVersion, "client-version", "", "calling client library version (default is none)")
 	RootCmd.Flags().BoolVarP(&printVersion, "version", "v", false, "prints the version")
@@ -83,4 +194,24 @@ func init() {
 	RootCmd.Flags().Float64Var(&timeoutSeconds, "timeout", 20.0, "timeout in seconds for http requests")
 	RootCmd.Flags().Uint8Var(&retries, "retries", 3, "number of times to retry requests on failure")
 	RootCmd.Flags().Float64Var(&retryBackoff, "retryBackoff", 1, "retry backoff factor: {retryBackoff} * (2 ^ {retries - 1})")
+	RootCmd.Flags().StringArrayVar(&pins, "pin", nil, "pin a host's TLS public key: host=sha256/{base64 spki hash}, no host is pinned by default (can be repeated)")
+	RootCmd.Flags().BoolVar(&pinReportOnly, "pin-report-only", false, "log TLS public key pin mismatches to stderr instead of failing (default is false)")
+	RootCmd.Flags().StringVar(&caStrategy, "ca-strategy", "auto", "where to load root certificates from: auto, system, file, or mozilla")
+	RootCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM file of additional root certificates (default is none)")
+	RootCmd.Flags().StringVar(&proxy, "proxy", "", "http, https, or socks5 proxy url (default is $HTTPS_PROXY/$HTTP_PROXY)")
+	RootCmd.Flags().StringVar(&proxyCredentialsFile, "proxy-credentials-file", "", "file containing proxy credentials as username:password (default is none)")
+	RootCmd.Flags().StringArrayVar(&headers, "header", nil, "extra request header as 'Name: value' (can be repeated)")
+	RootCmd.Flags().StringArrayVar(&resolve, "resolve", nil, "connect to host:port at addr instead of resolving it: host:port:addr (can be repeated)")
+	RootCmd.Flags().StringVar(&dnsServer, "dns-server", "", "DNS server ip[:port] to resolve hosts with (default is the system resolver)")
+	RootCmd.Flags().BoolVar(&metadataHeaders, "metadata-headers", false, "send client metadata as request headers instead of query params (default is false)")
+	RootCmd.Flags().Int64Var(&maxResponseBytes, "max-response-bytes", fetch.DefaultMaxBodyBytes, "maximum size of a server response in bytes")
+	RootCmd.Flags().BoolVar(&crossCheck, "cross-check", false, "also load from backup urls and compare with the primary response (default is false)")
+	RootCmd.Flags().StringVar(&crossCheckPolicy, "cross-check-policy", fetch.CrossCheckPolicyFail, "what to do when primary and backup responses disagree or can't be compared: fail or warn")
+	RootCmd.Flags().StringVar(&replayPolicy, "replay-policy", fetch.ReplayPolicyOff, "what to do when an older response is served again after a newer one was seen: off, warn or refuse (versions are tracked in the cache)")
+	RootCmd.Flags().StringVar(&knownSigners, "known-signers", fetch.KnownSignersOff, "record root and signer keys on first use, then on a change: off, warn or strict (fail until approved with trust accept)")
+	RootCmd.Flags().StringVar(&knownSignersFile, "known-signers-file", "", "where --known-signers records keys (default is $HOME/.envkey/known_signers)")
+	RootCmd.Flags().BoolVar(&strictResponse, "strict-response", false, "require a json content type and reject unknown or duplicate response fields (default is false)")
+
+	addFetchFlags(explainCmd)
+	addFetchFlags(trustAcceptCmd)
 }
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/envkey/envkey-fetch/fetch"
+
+	"github.com/spf13/cobra"
+)
+
+var trustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Manage the root and signer keys recorded by --known-signers.",
+}
+
+var trustAcceptCmd = &cobra.Command{
+	Use:   "accept [ENVKEY]",
+	Short: "Fetch and verify config, then record its root and signer keys as known, replacing the roots known before. Use this to approve a legitimate key rotation. Uses $ENVKEY if no ENVKEY is given. Values are never printed.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		envkey := os.Getenv("ENVKEY")
+		if len(args) > 0 {
+			envkey = args[0]
+		}
+
+		changes, err := fetch.AcceptSigners(envkey, fetchOptions())
+		exitIfErr(err)
+
+		if len(changes) == 0 {
+			fmt.Println("no changes")
+		}
+		for _, change := range changes {
+			fmt.Println("accepted: " + change.String())
+		}
+	},
+}
+
+func init() {
+	trustCmd.AddCommand(trustAcceptCmd)
+	RootCmd.AddCommand(trustCmd)
+}
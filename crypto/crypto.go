@@ -2,39 +2,109 @@ package crypto
 
 import (
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"io"
 	"io/ioutil"
-	"reflect"
-
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
-	"golang.org/x/crypto/openpgp/clearsign"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
-func ReadPrivkey(encryptedPrivkeyArmored, pw []byte) (openpgp.EntityList, error) {
+// config enables AEAD, which is only used to encrypt when every recipient key advertises support for it.
+var config = &packet.Config{AEADConfig: &packet.AEADConfig{}}
+
+// Key is an OpenPGP key with its identities and subkeys. RSA, Ed25519 and Curve25519 keys are supported. It's opaque, so other packages use keys through this package and the OpenPGP implementation isn't part of their API.
+type Key struct {
+	entity *openpgp.Entity
+}
+
+// Keyring is a list of keys.
+type Keyring []*Key
+
+// Serialize writes the key's public parts as binary OpenPGP packets.
+func (key *Key) Serialize(w io.Writer) error {
+	return key.entity.Serialize(w)
+}
+
+func keyring(entities openpgp.EntityList) Keyring {
+	keys := make(Keyring, len(entities))
+	for i, entity := range entities {
+		keys[i] = &Key{entity}
+	}
+	return keys
+}
+
+// complete reports whether every key in keys has a primary key. Functions taking a Keyring check it first, so a nil or empty key is an error rather than a panic.
+func (keys Keyring) complete() bool {
+	for _, key := range keys {
+		if key == nil || key.entity == nil || key.entity.PrimaryKey == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (keys Keyring) entities() openpgp.EntityList {
+	entities := make(openpgp.EntityList, len(keys))
+	for i, key := range keys {
+		entities[i] = key.entity
+	}
+	return entities
+}
+
+func ReadPrivkey(encryptedPrivkeyArmored, pw []byte) (Keyring, error) {
 	// Read the private key
 	entityList, err := ReadArmoredKey(encryptedPrivkeyArmored)
 	if err != nil {
 		return nil, err
 	}
-	entity := entityList[0]
-
-	// Get the passphrase and read the private key.
-	entity.PrivateKey.Decrypt(pw)
-	for _, subkey := range entity.Subkeys {
-		subkey.PrivateKey.Decrypt(pw)
+	if len(entityList) == 0 || entityList[0].entity.PrivateKey == nil {
+		return nil, errors.New("No private key.")
 	}
+	entity := entityList[0].entity
+
+	// Decrypt the primary key and subkeys together, so a shared passphrase is only derived once. A wrong passphrase leaves them encrypted, which VerifyPubkeyWithPrivkey reports.
+	entity.DecryptPrivateKeys(pw)
 
 	return entityList, nil
 }
 
-func ReadArmoredKey(armoredKey []byte) (openpgp.EntityList, error) {
+// ReadArmoredKey reads one or more keys. Public keys are memoized, so the keys returned may be shared and mustn't be modified.
+func ReadArmoredKey(armoredKey []byte) (keys Keyring, err error) {
+	if keys, ok := parsedKeys.get(armoredKey); ok {
+		return keys, nil
+	}
+
+	keys, err = readArmoredKey(armoredKey)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedKeys.put(armoredKey, keys)
+	return keys, nil
+}
+
+func readArmoredKey(armoredKey []byte) (Keyring, error) {
 	keyringFileBuffer := bytes.NewBuffer(armoredKey)
-	return openpgp.ReadArmoredKeyRing(keyringFileBuffer)
+	entityList, err := openpgp.ReadArmoredKeyRing(keyringFileBuffer)
+	return keyring(entityList), err
 }
 
-func MakeKeyring(decryptedPrivkey openpgp.EntityList, pubkeyArmored []byte) (openpgp.EntityList, error) {
+// Fingerprint returns the hex encoded fingerprint of the first key's primary key, or "" for an empty list.
+func Fingerprint(keys Keyring) string {
+	if len(keys) == 0 || !keys[:1].complete() {
+		return ""
+	}
+	return hex.EncodeToString(keys[0].entity.PrimaryKey.Fingerprint[:])
+}
+
+func MakeKeyring(decryptedPrivkey Keyring, pubkeyArmored []byte) (Keyring, error) {
 	pubkey, err := ReadArmoredKey(pubkeyArmored)
 	if err != nil {
 		return nil, err
@@ -42,35 +112,53 @@ func MakeKeyring(decryptedPrivkey openpgp.EntityList, pubkeyArmored []byte) (ope
 	return append(decryptedPrivkey, pubkey...), nil
 }
 
-func VerifyPubkeyWithPrivkey(pubkey, decryptedPrivkey openpgp.EntityList) error {
-	var encrypted, decrypted []byte
-	var err error
-
-	msg := []byte("test message")
+// VerifyPubkeyWithPrivkey checks that decryptedPrivkey is the private half of pubkey, by comparing the public parameters of the primary key and each subkey, and that the private keys were decrypted.
+func VerifyPubkeyWithPrivkey(pubkey, decryptedPrivkey Keyring) error {
+	if !(len(pubkey) == 1 && len(decryptedPrivkey) == 1 && pubkey.complete() && decryptedPrivkey.complete() && decryptedPrivkey[0].entity.PrivateKey != nil) {
+		return errors.New("Requires a single public key and a single private key.")
+	}
+	public, private := pubkey[0].entity, decryptedPrivkey[0].entity
 
-	encrypted, err = Encrypt(msg, pubkey)
-	if err != nil {
-		return err
+	if private.PrivateKey.Encrypted {
+		return errors.New("Private key could not be decrypted.")
 	}
 
-	decrypted, err = Decrypt(encrypted, decryptedPrivkey)
-	if err != nil {
-		return err
+	mismatch := errors.New("Public key does not match private key.")
+	if !samePublicKey(public.PrimaryKey, private.PrimaryKey) || len(public.Subkeys) != len(private.Subkeys) {
+		return mismatch
+	}
+	for i, subkey := range private.Subkeys {
+		if !samePublicKey(public.Subkeys[i].PublicKey, subkey.PublicKey) {
+			return mismatch
+		}
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			return errors.New("Private key could not be decrypted.")
+		}
 	}
 
-	if bytes.Equal(msg, decrypted) {
-		return nil
-	} else {
-		return errors.New("Decrypted message does not match original message.")
+	return nil
+}
+
+// samePublicKey compares serialized public key packets, which hold the algorithm, creation time and public parameters.
+func samePublicKey(a, b *packet.PublicKey) bool {
+	var bufA, bufB bytes.Buffer
+	if a == nil || b == nil || a.Serialize(&bufA) != nil || b.Serialize(&bufB) != nil {
+		return false
 	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
 }
 
-func Encrypt(msg []byte, pubkeys openpgp.EntityList) ([]byte, error) {
+// Encrypt encrypts msg to pubkeys, using AEAD when every recipient key supports it.
+func Encrypt(msg []byte, pubkeys Keyring) ([]byte, error) {
+	if len(pubkeys) == 0 || !pubkeys.complete() {
+		return nil, errors.New("Requires at least one public key.")
+	}
+
 	var encCloser, armorCloser io.WriteCloser
 	var err error
 
 	encbuf := new(bytes.Buffer)
-	encCloser, err = openpgp.Encrypt(encbuf, pubkeys, nil, nil, nil)
+	encCloser, err = openpgp.Encrypt(encbuf, pubkeys.entities(), nil, nil, config)
 	if err != nil {
 		return nil, err
 	}
@@ -101,28 +189,51 @@ func Encrypt(msg []byte, pubkeys openpgp.EntityList) ([]byte, error) {
 	return armorbuf.Bytes(), nil
 }
 
-func Decrypt(cipherArmored []byte, keys openpgp.EntityList) ([]byte, error) {
-	if !(len(keys) == 1 && keys[0].PrivateKey != nil) {
+func Decrypt(cipherArmored []byte, keys Keyring) ([]byte, error) {
+	if !(len(keys) == 1 && keys.complete() && keys[0].entity.PrivateKey != nil) {
 		return nil, errors.New("Requires a single private key.")
 	}
+	if keys[0].wiped() {
+		return nil, errors.New("Private key has been wiped.")
+	}
 	return readMessage(cipherArmored, keys)
 }
 
-func DecryptAndVerify(cipherArmored []byte, keys openpgp.EntityList) ([]byte, error) {
-	if !(len(keys) == 2 && keys[0].PrivateKey != nil && keys[1].PrivateKey == nil) {
+func DecryptAndVerify(cipherArmored []byte, keys Keyring) ([]byte, error) {
+	if !(len(keys) == 2 && keys.complete() && keys[0].entity.PrivateKey != nil && keys[1].entity.PrivateKey == nil) {
 		return nil, errors.New("Requires a single private key and a single public key.")
 	}
+	if keys[0].wiped() {
+		return nil, errors.New("Private key has been wiped.")
+	}
 
 	return readMessage(cipherArmored, keys)
 }
 
-func VerifySignedCleartext(message []byte, keys openpgp.EntityList) ([]byte, error) {
-	if !(len(keys) == 1 && keys[0].PrivateKey == nil) {
+func VerifySignedCleartext(message []byte, keys Keyring) ([]byte, error) {
+	if !(len(keys) == 1 && keys.complete() && keys[0].entity.PrivateKey == nil) {
 		return nil, errors.New("Requires a single public key.")
 	}
 
+	if emptyCleartext(message) {
+		return nil, errors.New("Signed message is empty.")
+	}
+
 	block, _ := clearsign.Decode(message)
-	_, err := openpgp.CheckDetachedSignature(keys, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
+	if block == nil || block.ArmoredSignature == nil {
+		return nil, errors.New("No signed message found.")
+	}
+	signature, err := ioutil.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkSigningKey(keys, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = openpgp.CheckDetachedSignature(keys.entities(), bytes.NewBuffer(block.Bytes), bytes.NewReader(signature), config)
 
 	if err != nil {
 		return nil, err
@@ -131,14 +242,96 @@ func VerifySignedCleartext(message []byte, keys openpgp.EntityList) ([]byte, err
 	return block.Bytes, nil
 }
 
-func VerifyPubkeySignature(signedPubkey, signerPubkey openpgp.EntityList) error {
-	signedKey := signedPubkey[0]
-	signerKey := signerPubkey[0]
+var signedMessageStart = []byte("-----BEGIN PGP SIGNED MESSAGE-----")
+
+// emptyCleartext reports whether the clearsigned message that clearsign.Decode would find in message has no text, so its signature starts right after the headers. Decode panics on those, and envkey never signs empty text.
+func emptyCleartext(message []byte) bool {
+	i := 0
+	if !bytes.HasPrefix(message, signedMessageStart) {
+		i = bytes.Index(message, append([]byte("\n"), signedMessageStart...))
+		if i < 0 {
+			return false
+		}
+	}
+
+	lines := strings.Split(string(message[i:]), "\n")
+	for j := 1; j < len(lines)-1; j++ {
+		if strings.TrimSpace(lines[j]) == "" {
+			return strings.TrimSuffix(lines[j+1], "\r") == "-----BEGIN PGP SIGNATURE-----"
+		}
+	}
+	return false
+}
+
+// checkSigningKey checks the key that made signature, if it's in keys. Otherwise verifying the signature fails anyway.
+func checkSigningKey(keys Keyring, signature []byte) error {
+	p, err := packet.Read(bytes.NewReader(signature))
+	if err != nil {
+		return err
+	}
+
+	sig, ok := p.(*packet.Signature)
+	if !ok || sig.IssuerKeyId == nil || *sig.IssuerKeyId == 0 {
+		return nil
+	}
+
+	return checkKeyInList(keys, *sig.IssuerKeyId, UsageSign)
+}
+
+// checkKeyInList checks every key in keys with keyId, since any of them could be the one a signature is verified with. Without a match there's nothing to check, as verifying fails anyway.
+func checkKeyInList(keys Keyring, keyId uint64, usage Usage) error {
+	now := time.Now()
+	for _, key := range keys.entities().KeysById(keyId) {
+		_, err := checkKey(key.Entity, keyId, usage, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	signedIdentityName := reflect.ValueOf(signedKey.Identities).MapKeys()[0].Interface().(string)
-	signature := signedKey.Identities[signedIdentityName].Signatures[0]
+// VerifyPubkeySignature checks that signerPubkey's primary key certified one of signedPubkey's identities, and that both keys are valid. Every identity and certification is tried, in order by identity name, so the result doesn't depend on map order.
+func VerifyPubkeySignature(signedPubkey, signerPubkey Keyring) error {
+	if len(signedPubkey) == 0 || len(signerPubkey) == 0 || !signedPubkey[:1].complete() || !signerPubkey[:1].complete() {
+		return errors.New("Requires a signed public key and a signer public key.")
+	}
+	signedKey := signedPubkey[0].entity
+	signerKey := signerPubkey[0].entity
+	now := time.Now()
 
-	return signerKey.PrimaryKey.VerifyUserIdSignature(signedIdentityName, signedKey.PrimaryKey, signature)
+	_, err := checkKey(signerKey, signerKey.PrimaryKey.KeyId, UsageCertify, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = checkKey(signedKey, 0, 0, now)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range signedKey.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		identity := signedKey.Identities[name]
+		if identity.Revoked(now) {
+			continue
+		}
+
+		for _, signature := range identity.Signatures {
+			if !isCertification(signature) || signature.SigExpired(now) || !signature.CheckKeyIdOrFingerprint(signerKey.PrimaryKey) {
+				continue
+			}
+			if signerKey.PrimaryKey.VerifyUserIdSignature(name, signedKey.PrimaryKey, signature) == nil {
+				return nil
+			}
+		}
+	}
+
+	return keyError(ErrNotCertified, signedKey.PrimaryKey, time.Time{})
 }
 
 func VerifyPubkeyArmoredSignature(signedPubkeyArmored, signerPubkeyArmored []byte) error {
@@ -155,7 +348,7 @@ func VerifyPubkeyArmoredSignature(signedPubkeyArmored, signerPubkeyArmored []byt
 	return VerifyPubkeySignature(signedPubkey, signerPubkey)
 }
 
-func readMessage(armoredMessage []byte, keys openpgp.EntityList) ([]byte, error) {
+func readMessage(armoredMessage []byte, keys Keyring) ([]byte, error) {
 	// Decode armored message
 	decbuf := bytes.NewBuffer(armoredMessage)
 	result, err := armor.Decode(decbuf)
@@ -164,16 +357,31 @@ func readMessage(armoredMessage []byte, keys openpgp.EntityList) ([]byte, error)
 	}
 
 	// Decrypt with private key
-	md, err := openpgp.ReadMessage(result.Body, keys, nil, nil)
+	md, err := openpgp.ReadMessage(result.Body, keys.entities(), nil, config)
 	if err != nil {
 		return nil, err
 	}
 
+	if md.DecryptedWith.Entity != nil && md.DecryptedWith.PublicKey != nil {
+		_, err = checkKey(md.DecryptedWith.Entity, md.DecryptedWith.PublicKey.KeyId, UsageEncrypt, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a key that isn't allowed to sign isn't set as SignedBy, so check it first for a specific error
+	if len(keys) == 2 && md.IsSigned {
+		err = checkKeyInList(keys[1:], md.SignedByKeyId, UsageSign)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// If pubkey included, verify
 	if len(keys) == 2 {
 		if md.SignedBy == nil || md.SignedBy.PublicKey == nil {
 			return nil, errors.New("Verifying public key included, but message is not signed.")
-		} else if md.SignedBy.PublicKey.Fingerprint != keys[1].PrimaryKey.Fingerprint {
+		} else if !bytes.Equal(md.SignedBy.PublicKey.Fingerprint, keys[1].entity.PrimaryKey.Fingerprint) {
 			return nil, errors.New("Signature pubkey doesn't match signing pubkey.")
 		}
 	}
@@ -0,0 +1,55 @@
+package crypto_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/crypto"
+)
+
+func BenchmarkVerifyPubkeyWithPrivkey(b *testing.B) {
+	decryptedPrivkey, _ := crypto.ReadPrivkey(encryptedPrivkey, validPassphrase)
+	pubkey, _ := crypto.ReadArmoredKey(pubkeyArmored)
+
+	b.Run("compare keys", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := crypto.VerifyPubkeyWithPrivkey(pubkey, decryptedPrivkey); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// what VerifyPubkeyWithPrivkey used to do
+	b.Run("encrypt and decrypt", func(b *testing.B) {
+		msg := []byte("test message")
+		for i := 0; i < b.N; i++ {
+			encrypted, err := crypto.Encrypt(msg, pubkey)
+			if err != nil {
+				b.Fatal(err)
+			}
+			decrypted, err := crypto.Decrypt(encrypted, decryptedPrivkey)
+			if err != nil || !bytes.Equal(msg, decrypted) {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkReadArmoredKey(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := crypto.ReadArmoredKey(signedPubkeyArmored); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			crypto.ClearKeyCache()
+			if _, err := crypto.ReadArmoredKey(signedPubkeyArmored); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package crypto_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/crypto"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// Outputs recorded with golang.org/x/crypto/openpgp, which crypto used before.
+func TestFixtureCompatibility(t *testing.T) {
+	decryptedPrivkey, _ := crypto.ReadPrivkey(rawEnvEncryptedPrivkey, rawEnvPassphrase)
+	keys, _ := crypto.MakeKeyring(decryptedPrivkey, pubkeyArmored)
+	decrypted, err := crypto.DecryptAndVerify(signedEncryptedMessage, keys)
+	assert.Nil(t, err, "Should not return an error.")
+	assert.Equal(t, `{"GO_TEST":"it","GO_TEST_2":"works!"}`, string(decrypted))
+
+	pubkey, _ := crypto.ReadArmoredKey(pubkeyArmored)
+	assert.Equal(t, "ad80ac3bcec7047db976a15a4dfbdf19118336b4", crypto.Fingerprint(pubkey))
+
+	verified, err := crypto.VerifySignedCleartext(signedMessage, pubkey)
+	assert.Nil(t, err, "Should not return an error.")
+	sum := sha256.Sum256(verified)
+	assert.Equal(t, "bb651f18b4d95940ea9cf22045d6dff07b57af5f21ffd6378e8db010954d468e", hex.EncodeToString(sum[:]))
+
+	for _, armored := range [][]byte{pubkeyArmored, signedPubkeyArmored, invalidPubkeyArmored} {
+		block, err := armor.Decode(bytes.NewReader(armored))
+		assert.Nil(t, err, "Should not return an error.")
+		raw, _ := ioutil.ReadAll(block.Body)
+
+		key, err := crypto.ReadArmoredKey(armored)
+		assert.Nil(t, err, "Should not return an error.")
+		buf := &bytes.Buffer{}
+		key[0].Serialize(buf)
+		assert.Equal(t, raw, buf.Bytes(), "Should serialize the key unchanged.")
+	}
+}
+
+func TestModernKeys(t *testing.T) {
+	for _, algo := range []packet.PublicKeyAlgorithm{packet.PubKeyAlgoEdDSA, packet.PubKeyAlgoEd25519} {
+		config := &packet.Config{Algorithm: algo, AEADConfig: &packet.AEADConfig{}}
+		envkey := newEntityWithConfig(t, config)
+		signer := newEntityWithConfig(t, config)
+
+		decryptedPrivkey, err := crypto.ReadPrivkey(armoredPrivkey(t, envkey), []byte("unused"))
+		assert.Nil(t, err, "Should not return an error.")
+		keys, err := crypto.MakeKeyring(decryptedPrivkey, armoredPubkey(t, signer))
+		assert.Nil(t, err, "Should not return an error.")
+
+		// signed and encrypted with AEAD, since the key advertises support for it
+		buf := &bytes.Buffer{}
+		w, _ := armor.Encode(buf, "PGP MESSAGE", nil)
+		plaintext, err := openpgp.Encrypt(w, []*openpgp.Entity{roundTrip(t, envkey)}, signer, nil, config)
+		assert.Nil(t, err, "Should not return an error.")
+		plaintext.Write([]byte("test message"))
+		plaintext.Close()
+		w.Close()
+		assert.Equal(t, 2, encryptedVersion(t, buf.Bytes()), "Should encrypt with AEAD.")
+
+		decrypted, err := crypto.DecryptAndVerify(buf.Bytes(), keys)
+		assert.Nil(t, err, "Should not return an error.")
+		assert.Equal(t, "test message", string(decrypted))
+
+		encrypted, err := crypto.Encrypt([]byte("test message"), crypto.Keyring{toKey(t, envkey)})
+		assert.Nil(t, err, "Should not return an error.")
+		assert.Equal(t, 2, encryptedVersion(t, encrypted), "Should encrypt with AEAD.")
+		decrypted, err = crypto.Decrypt(encrypted, decryptedPrivkey)
+		assert.Nil(t, err, "Should not return an error.")
+		assert.Equal(t, "test message", string(decrypted))
+
+		buf = &bytes.Buffer{}
+		w, _ = clearsign.Encode(buf, signer.PrivateKey, nil)
+		w.Write([]byte("test message"))
+		w.Close()
+		verified, err := crypto.VerifySignedCleartext(buf.Bytes(), crypto.Keyring{toKey(t, signer)})
+		assert.Nil(t, err, "Should not return an error.")
+		assert.Equal(t, "test message", string(verified))
+
+		err = envkey.SignIdentity(testIdentity, signer, nil)
+		assert.Nil(t, err, "Should not return an error.")
+		err = crypto.VerifyPubkeySignature(crypto.Keyring{toKey(t, envkey)}, crypto.Keyring{toKey(t, signer)})
+		assert.Nil(t, err, "Should not return an error.")
+
+		crypto.WipePrivkey(decryptedPrivkey)
+		_, err = crypto.Decrypt(encrypted, decryptedPrivkey)
+		assert.NotNil(t, err, "Should not decrypt with a wiped key.")
+	}
+}
+
+func newEntityWithConfig(t *testing.T, config *packet.Config) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("test", "", "test@envkey.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func armoredPrivkey(t *testing.T, entity *openpgp.Entity) []byte {
+	buf := &bytes.Buffer{}
+	w, _ := armor.Encode(buf, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func armoredPubkey(t *testing.T, entity *openpgp.Entity) []byte {
+	buf := &bytes.Buffer{}
+	w, _ := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+// encryptedVersion is the version of the encrypted data packet in message: 1 without AEAD, 2 with it.
+func encryptedVersion(t *testing.T, message []byte) int {
+	block, err := armor.Decode(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := packet.NewReader(block.Body)
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted, ok := p.(*packet.SymmetricallyEncrypted); ok {
+			return encrypted.Version
+		}
+	}
+}
@@ -32,6 +32,21 @@ func TestDecryptAndVerify(t *testing.T) {
 	assert.NotNil(t, err, "Should return an error.")
 }
 
+func TestWipePrivkey(t *testing.T) {
+	decryptedPrivkey, _ := crypto.ReadPrivkey(rawEnvEncryptedPrivkey, rawEnvPassphrase)
+
+	crypto.WipePrivkey(decryptedPrivkey)
+	keys, _ := crypto.MakeKeyring(decryptedPrivkey, pubkeyArmored)
+	_, err := crypto.DecryptAndVerify(signedEncryptedMessage, keys)
+	assert.NotNil(t, err, "Should not decrypt with a wiped key.")
+
+	// the same key read again isn't affected
+	decryptedPrivkey, _ = crypto.ReadPrivkey(rawEnvEncryptedPrivkey, rawEnvPassphrase)
+	keys, _ = crypto.MakeKeyring(decryptedPrivkey, pubkeyArmored)
+	_, err = crypto.DecryptAndVerify(signedEncryptedMessage, keys)
+	assert.Nil(t, err, "Should decrypt with a key read again.")
+}
+
 func TestVerifySignedCleartext(t *testing.T) {
 	var err error
 
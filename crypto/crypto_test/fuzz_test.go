@@ -0,0 +1,78 @@
+package crypto_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/crypto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMalformedInput(t *testing.T) {
+	var err error
+	pubkey, _ := crypto.ReadArmoredKey(pubkeyArmored)
+
+	_, err = crypto.VerifySignedCleartext([]byte("not signed"), pubkey)
+	assert.NotNil(t, err, "Should return an error.")
+
+	_, err = crypto.ReadPrivkey(pubkeyArmored, validPassphrase)
+	assert.NotNil(t, err, "Should return an error.")
+
+	err = crypto.VerifyPubkeySignature(crypto.Keyring{}, pubkey)
+	assert.NotNil(t, err, "Should return an error.")
+}
+
+func FuzzReadArmoredKey(f *testing.F) {
+	for _, seed := range [][]byte{pubkeyArmored, signedPubkeyArmored, invalidPubkeyArmored, revokedPubkeyArmored, encryptedPrivkey, {}} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, armored []byte) {
+		keys, err := crypto.ReadArmoredKey(armored)
+		if err != nil {
+			return
+		}
+		crypto.Fingerprint(keys)
+		for _, key := range keys {
+			crypto.CheckKey(key, crypto.UsageCertify, time.Now())
+			crypto.CheckKey(key, crypto.UsageSign, time.Now())
+			crypto.CheckKey(key, crypto.UsageEncrypt, time.Now())
+		}
+		crypto.VerifyPubkeySignature(keys, keys)
+		crypto.VerifyPubkeyArmoredSignature(armored, pubkeyArmored)
+	})
+}
+
+func FuzzReadPrivkey(f *testing.F) {
+	f.Add(encryptedPrivkey, validPassphrase)
+	f.Add(rawEnvEncryptedPrivkey, rawEnvPassphrase)
+	f.Add(pubkeyArmored, validPassphrase)
+	f.Fuzz(func(t *testing.T, armored, pw []byte) {
+		privkey, err := crypto.ReadPrivkey(armored, pw)
+		if err != nil {
+			return
+		}
+		pubkey, _ := crypto.ReadArmoredKey(pubkeyArmored)
+		crypto.VerifyPubkeyWithPrivkey(pubkey, privkey)
+	})
+}
+
+func FuzzVerifySignedCleartext(f *testing.F) {
+	f.Add(signedMessage)
+	f.Add([]byte{})
+	pubkey, _ := crypto.ReadArmoredKey(pubkeyArmored)
+	f.Fuzz(func(t *testing.T, message []byte) {
+		crypto.VerifySignedCleartext(message, pubkey)
+	})
+}
+
+func FuzzDecryptAndVerify(f *testing.F) {
+	f.Add(signedEncryptedMessage)
+	f.Add([]byte{})
+	decryptedPrivkey, _ := crypto.ReadPrivkey(rawEnvEncryptedPrivkey, rawEnvPassphrase)
+	keys, _ := crypto.MakeKeyring(decryptedPrivkey, pubkeyArmored)
+	f.Fuzz(func(t *testing.T, message []byte) {
+		crypto.DecryptAndVerify(message, keys)
+		crypto.Decrypt(message, decryptedPrivkey)
+	})
+}
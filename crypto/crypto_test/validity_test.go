@@ -0,0 +1,156 @@
+package crypto_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/crypto"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckKey(t *testing.T) {
+	now := time.Now()
+
+	pubkey, _ := crypto.ReadArmoredKey(pubkeyArmored)
+	assert.Nil(t, crypto.CheckKey(pubkey[0], crypto.UsageSign, now), "Should not return an error.")
+	assert.Nil(t, crypto.CheckKey(pubkey[0], crypto.UsageEncrypt, now), "Should not return an error.")
+
+	revoked, err := crypto.ReadArmoredKey(revokedPubkeyArmored)
+	assert.Nil(t, err, "Should not return an error.")
+	err = crypto.CheckKey(revoked[0], 0, now)
+	assert.True(t, errors.Is(err, crypto.ErrKeyRevoked), "Should return ErrKeyRevoked.")
+	assert.Contains(t, err.Error(), "44685a38c0f84b3b6320dbbc1c5735bad83349e7")
+
+	expiring := newEntity(t)
+	lifetime := uint32(3600)
+	expiring.Identities[testIdentity].SelfSignature.KeyLifetimeSecs = &lifetime
+	resign(t, expiring)
+	assert.Nil(t, crypto.CheckKey(toKey(t, expiring), 0, now), "Should not return an error.")
+	err = crypto.CheckKey(toKey(t, expiring), 0, now.Add(2*time.Hour))
+	assert.True(t, errors.Is(err, crypto.ErrKeyExpired), "Should return ErrKeyExpired.")
+
+	signOnly := newEntity(t)
+	selfSig := signOnly.Identities[testIdentity].SelfSignature
+	selfSig.FlagsValid = true
+	selfSig.FlagCertify = true
+	selfSig.FlagSign = true
+	selfSig.FlagEncryptCommunications = false
+	selfSig.FlagEncryptStorage = false
+	signOnly.Subkeys = nil
+	resign(t, signOnly)
+	assert.Nil(t, crypto.CheckKey(toKey(t, signOnly), crypto.UsageSign, now), "Should not return an error.")
+	err = crypto.CheckKey(toKey(t, signOnly), crypto.UsageEncrypt, now)
+	assert.True(t, errors.Is(err, crypto.ErrKeyUsage), "Should return ErrKeyUsage.")
+
+	revokedIdentity := newEntity(t)
+	revocation := &packet.Signature{
+		SigType:      0x30,
+		PubKeyAlgo:   revokedIdentity.PrimaryKey.PubKeyAlgo,
+		Hash:         selfSig.Hash,
+		CreationTime: now,
+		IssuerKeyId:  &revokedIdentity.PrimaryKey.KeyId,
+	}
+	err = revocation.SignUserId(testIdentity, revokedIdentity.PrimaryKey, revokedIdentity.PrivateKey, nil)
+	assert.Nil(t, err, "Should not return an error.")
+	identity := revokedIdentity.Identities[testIdentity]
+	identity.Signatures = append(identity.Signatures, revocation)
+	err = crypto.CheckKey(toKey(t, revokedIdentity), 0, now)
+	assert.True(t, errors.Is(err, crypto.ErrNoValidIdentity), "Should return ErrNoValidIdentity.")
+}
+
+func TestVerifyPubkeySignatureValidity(t *testing.T) {
+	signer := newEntity(t)
+	signed := newEntity(t)
+	err := signed.SignIdentity(testIdentity, signer, nil)
+	assert.Nil(t, err, "Should not return an error.")
+
+	err = crypto.VerifyPubkeySignature(crypto.Keyring{toKey(t, signed)}, crypto.Keyring{toKey(t, signer)})
+	assert.Nil(t, err, "Should not return an error.")
+
+	err = crypto.VerifyPubkeySignature(crypto.Keyring{toKey(t, signed)}, crypto.Keyring{toKey(t, newEntity(t))})
+	assert.True(t, errors.Is(err, crypto.ErrNotCertified), "Should return ErrNotCertified.")
+
+	// created two hours ago, expired after one, and certifying while it was still valid
+	past := func(ago time.Duration) *packet.Config {
+		return &packet.Config{RSABits: 1024, Time: func() time.Time { return time.Now().Add(-ago) }}
+	}
+	expired := newEntityWithConfig(t, past(2*time.Hour))
+	lifetime := uint32(3600)
+	expired.Identities[testIdentity].SelfSignature.KeyLifetimeSecs = &lifetime
+	resign(t, expired)
+	signed = newEntity(t)
+	err = signed.SignIdentity(testIdentity, expired, past(90*time.Minute))
+	assert.Nil(t, err, "Should not return an error.")
+	err = crypto.VerifyPubkeySignature(crypto.Keyring{toKey(t, signed)}, crypto.Keyring{toKey(t, expired)})
+	assert.True(t, errors.Is(err, crypto.ErrKeyExpired), "Should return ErrKeyExpired.")
+}
+
+const testIdentity = "test <test@envkey.com>"
+
+func newEntity(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("test", "", "test@envkey.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// resign signs the self-signature again after changing it.
+func resign(t *testing.T, entity *openpgp.Entity) {
+	identity := entity.Identities[testIdentity]
+	err := identity.SelfSignature.SignUserId(testIdentity, entity.PrimaryKey, entity.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// toKey reads entity's public key with crypto.
+func toKey(t *testing.T, entity *openpgp.Entity) *crypto.Key {
+	keys, err := crypto.ReadArmoredKey(armoredPubkey(t, entity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys[0]
+}
+
+// roundTrip serializes entity's public key and reads it back, so it holds only what's sent over the wire.
+func roundTrip(t *testing.T, entity *openpgp.Entity) *openpgp.Entity {
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredPubkey(t, entity)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys[0]
+}
+
+// generated and revoked with gpg
+var revokedPubkeyArmored = []byte(`
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBGrUunUBCACv3cMAq6O5sSzF/6Yf+o0dVzDc1gqbpjNGKB3o07UN8nK6PfQB
+eAlbYBZ5LA07KTc1BoANiFv30+GYNcPl14ytbJQv34YicSYsvpZOFiCYsts6D4kD
+XHft8bQBrD9QF6V2Y6raeu/HL8sAaCozcxRSSSgm5E4eIQMsw9WjtBZGjzdXY5sZ
+IF4+RDIDE9vBD1p9HcefpeIxI0Njg2MKSzoz3Uh32WoDichVLVTh7RmpaXI5m2z1
+1Xq3VwXFlrZosQz6JLUgdyxDFcheqelMNOsuOiQzZ49J56wKOjzoVxV5E+bvFLT5
+2sDLUcXkCri47Gvtq0NO1UL2FZRyigS9kYK1ABEBAAGJATYEIAEKACAWIQREaFo4
+wPhLO2Mg27wcVzW62DNJ5wUCatS6dQIdAAAKCRAcVzW62DNJ57ZaCACMy/heTCSm
+ERT8TBbery8PZGP8nWZgU9DaDShGg0lNA9j3EDYr1RgK0kNfgy1s6tjxeJpcrM7A
+T6zhwrhw/5ukcgkSbMzyXQVShOAhcChDbrkV3K4J8/3/oq21RIgxliyDQTkKc8bW
+RGecDgpFiJNO8WqPA1SWcpPw1iSaZbNzLOcFceWon+A5vGiJIWy/9iYxG92T5Wcj
+ZWyX46jq/nI+liQOELsPJxWnTSG/0bTUf/HAgHYIz0oBdN8cIOAgkAE2A3PHVesQ
+Ao5PpNSj/RAjUwLf7bSh2r5TMqfYNSBUVFfDEf3fzwOO3XG9KvvddcGqHny71w7w
+aEZUoi3psppDtBJyZXZva2VkQGVudmtleS5jb22JAU4EEwEKADgWIQREaFo4wPhL
+O2Mg27wcVzW62DNJ5wUCatS6dQIbAwULCQgHAgYVCgkICwIEFgIDAQIeAQIXgAAK
+CRAcVzW62DNJ52tXB/sGJrS3jXZMLPiK4Zuv6gJMyd1+BcIVCU/UnI/387OLPIbU
+4e0CdT08MznP/ctsDR/gHRnUg8hUrHP4ghn6X0jakdOWvhlOYDGQgqQmsLZxSF3d
+LORfpaZy6GufY8kxqIx2hAiym+8zwpOwJXta+SPlQdKFalyFl/HucXzxD1rWcOwe
+Ur93Eo86LSCVhM1385xdAXRrJVaEBm1I92sli53U6J0AVX5c2MVO1fWN2wjf8HZ2
+3XOY03kdMeaUr/8/YqV8He3NkYoM47QDyGKIQzcFo1pnE2au4xI9CI/KdczEAkzO
+Q5lZU+4kAgBdvahFNq/QGHAPItGJ8HiX8Zj/iAvp
+=27Pm
+-----END PGP PUBLIC KEY BLOCK-----
+`)
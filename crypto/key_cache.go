@@ -0,0 +1,53 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"sync"
+)
+
+// maxCachedKeys bounds memory in long running processes. The cache starts over when it's reached.
+const maxCachedKeys = 1024
+
+// keyCache memoizes parsed public keys, so repeated fetches don't parse the same keys and verify their self-signatures again. Keys are looked up by a digest of their armored form rather than by fingerprint, since the same key can be served with different certifications, which the trust chain depends on.
+// Private keys aren't cached.
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[[sha256.Size]byte]Keyring
+}
+
+var parsedKeys = &keyCache{keys: map[[sha256.Size]byte]Keyring{}}
+
+// ClearKeyCache forgets memoized keys.
+func ClearKeyCache() {
+	parsedKeys.mu.Lock()
+	defer parsedKeys.mu.Unlock()
+	parsedKeys.keys = map[[sha256.Size]byte]Keyring{}
+}
+
+// get returns a copy of the cached Keyring, so appending to it can't change what's cached.
+func (cache *keyCache) get(armoredKey []byte) (Keyring, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	keys, ok := cache.keys[sha256.Sum256(armoredKey)]
+	if !ok {
+		return nil, false
+	}
+	return append(Keyring{}, keys...), true
+}
+
+func (cache *keyCache) put(armoredKey []byte, keys Keyring) {
+	for _, key := range keys {
+		if key.entity.PrivateKey != nil {
+			return
+		}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if len(cache.keys) >= maxCachedKeys {
+		cache.keys = map[[sha256.Size]byte]Keyring{}
+	}
+	cache.keys[sha256.Sum256(armoredKey)] = append(Keyring{}, keys...)
+}
@@ -0,0 +1,207 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+var (
+	ErrKeyExpired      = errors.New("key expired")
+	ErrKeyRevoked      = errors.New("key revoked")
+	ErrKeyUsage        = errors.New("key not allowed for this use")
+	ErrNoValidIdentity = errors.New("key has no valid identity")
+	ErrNotCertified    = errors.New("key not certified by signer")
+)
+
+// Usage is what a key is used for, checked against the key flags in its self-signatures.
+type Usage uint8
+
+const (
+	UsageCertify Usage = 1 << iota
+	UsageSign
+	UsageEncrypt
+)
+
+func (usage Usage) String() string {
+	switch usage {
+	case UsageCertify:
+		return "certifying"
+	case UsageSign:
+		return "signing"
+	case UsageEncrypt:
+		return "encryption"
+	default:
+		return "any use"
+	}
+}
+
+// CheckKey checks that key's primary key is valid at now: not revoked, with at least one identity that isn't revoked, and not expired. The primary identity's self-signature (or that of the first identity by name, if none is marked primary) sets expiry and key flags, so the result doesn't depend on map order.
+// If usage is set, key must have a valid primary key or subkey with flags allowing it. Keys whose self-signatures have no flags are allowed any use.
+func CheckKey(key *Key, usage Usage, now time.Time) error {
+	if key == nil {
+		return errors.New("No key.")
+	}
+	_, err := checkKey(key.entity, 0, usage, now)
+	return err
+}
+
+// CheckKeyId is CheckKey for the primary key or subkey of key with keyId. The primary key has to be valid for a subkey to be.
+func CheckKeyId(key *Key, keyId uint64, usage Usage, now time.Time) error {
+	if key == nil {
+		return errors.New("No key.")
+	}
+	_, err := checkKey(key.entity, keyId, usage, now)
+	return err
+}
+
+// checkKey returns the key it checked. keyId 0 checks the primary key, or with a usage, any key that allows it.
+func checkKey(entity *openpgp.Entity, keyId uint64, usage Usage, now time.Time) (*packet.PublicKey, error) {
+	if entity == nil || entity.PrimaryKey == nil {
+		return nil, errors.New("No key.")
+	}
+
+	// revocations are verified when the key is read
+	if entity.Revoked(now) {
+		return nil, keyError(ErrKeyRevoked, entity.PrimaryKey, entity.Revocations[0].CreationTime)
+	}
+
+	selfSignature := primarySelfSignature(entity, now)
+	if selfSignature == nil {
+		return nil, keyError(ErrNoValidIdentity, entity.PrimaryKey, time.Time{})
+	}
+
+	if expiry, ok := expiresAt(entity.PrimaryKey, selfSignature); ok && now.After(expiry) {
+		return nil, keyError(ErrKeyExpired, entity.PrimaryKey, expiry)
+	}
+	if selfSignature.SigExpired(now) {
+		return nil, keyError(ErrKeyExpired, entity.PrimaryKey, time.Time{})
+	}
+
+	if keyId == 0 && usage == 0 || keyId == entity.PrimaryKey.KeyId {
+		if !allows(selfSignature, usage) {
+			return nil, usageError(entity.PrimaryKey, usage)
+		}
+		return entity.PrimaryKey, nil
+	}
+
+	var subkeyErr error
+	for _, subkey := range entity.Subkeys {
+		if keyId != 0 && subkey.PublicKey.KeyId != keyId {
+			continue
+		}
+		subkeyErr = checkSubkey(subkey, usage, now)
+		if subkeyErr == nil {
+			return subkey.PublicKey, nil
+		}
+	}
+
+	if keyId == 0 {
+		if allows(selfSignature, usage) {
+			return entity.PrimaryKey, nil
+		}
+		if subkeyErr == nil {
+			subkeyErr = usageError(entity.PrimaryKey, usage)
+		}
+	}
+	if subkeyErr == nil {
+		return nil, fmt.Errorf("No key with id %016x.", keyId)
+	}
+	return nil, subkeyErr
+}
+
+func checkSubkey(subkey openpgp.Subkey, usage Usage, now time.Time) error {
+	if subkey.Revoked(now) {
+		return keyError(ErrKeyRevoked, subkey.PublicKey, subkey.Revocations[0].CreationTime)
+	}
+	if expiry, ok := expiresAt(subkey.PublicKey, subkey.Sig); ok && now.After(expiry) {
+		return keyError(ErrKeyExpired, subkey.PublicKey, expiry)
+	}
+	if subkey.Sig.SigExpired(now) {
+		return keyError(ErrKeyExpired, subkey.PublicKey, time.Time{})
+	}
+	if !allows(subkey.Sig, usage) {
+		return usageError(subkey.PublicKey, usage)
+	}
+	return nil
+}
+
+// primarySelfSignature is the self-signature of the identity marked primary, or of the first by name. Identities revoked by the key itself are skipped. A v6 key's properties are on its direct-key signature instead.
+func primarySelfSignature(entity *openpgp.Entity, now time.Time) *packet.Signature {
+	if entity.PrimaryKey.Version == 6 {
+		return entity.SelfSignature
+	}
+
+	names := []string{}
+	for name := range entity.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var first *packet.Signature
+	for _, name := range names {
+		identity := entity.Identities[name]
+		if identity.SelfSignature == nil || identity.Revoked(now) {
+			continue
+		}
+		if identity.SelfSignature.IsPrimaryId != nil && *identity.SelfSignature.IsPrimaryId {
+			return identity.SelfSignature
+		}
+		if first == nil {
+			first = identity.SelfSignature
+		}
+	}
+	return first
+}
+
+func isCertification(sig *packet.Signature) bool {
+	switch sig.SigType {
+	case packet.SigTypeGenericCert, packet.SigTypePersonaCert, packet.SigTypeCasualCert, packet.SigTypePositiveCert:
+		return true
+	}
+	return false
+}
+
+// expiresAt is when key expires according to sig. Key lifetimes count from the key's creation, not the signature's.
+func expiresAt(key *packet.PublicKey, sig *packet.Signature) (time.Time, bool) {
+	if sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return time.Time{}, false
+	}
+	return key.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second), true
+}
+
+func allows(sig *packet.Signature, usage Usage) bool {
+	if usage == 0 || !sig.FlagsValid {
+		return true
+	}
+
+	switch usage {
+	case UsageCertify:
+		return sig.FlagCertify
+	case UsageSign:
+		return sig.FlagSign
+	case UsageEncrypt:
+		return sig.FlagEncryptCommunications || sig.FlagEncryptStorage
+	}
+	return false
+}
+
+func keyError(err error, key *packet.PublicKey, at time.Time) error {
+	if at.IsZero() {
+		return fmt.Errorf("%w: %s", err, keyFingerprint(key))
+	}
+	return fmt.Errorf("%w: %s at %s", err, keyFingerprint(key), at.UTC().Format(time.RFC3339))
+}
+
+func usageError(key *packet.PublicKey, usage Usage) error {
+	return fmt.Errorf("%w: %s isn't allowed for %s", ErrKeyUsage, keyFingerprint(key), usage)
+}
+
+func keyFingerprint(key *packet.PublicKey) string {
+	return hex.EncodeToString(key.Fingerprint[:])
+}
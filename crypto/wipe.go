@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/ProtonMail/go-crypto/openpgp/ecdh"
+	"github.com/ProtonMail/go-crypto/openpgp/ed25519"
+	"github.com/ProtonMail/go-crypto/openpgp/ed448"
+	"github.com/ProtonMail/go-crypto/openpgp/eddsa"
+	"github.com/ProtonMail/go-crypto/openpgp/elgamal"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/go-crypto/openpgp/x25519"
+	"github.com/ProtonMail/go-crypto/openpgp/x448"
+
+	"github.com/envkey/envkey-fetch/internal/secmem"
+)
+
+// WipePrivkey overwrites the decrypted secret key material of keys read by ReadPrivkey and drops it, so the keys can't be used to decrypt or sign afterwards. Copies the standard library makes while using a key, such as the precomputed values inside an RSA key, are out of reach and left to the garbage collector.
+func WipePrivkey(keys Keyring) {
+	for _, key := range keys {
+		if key == nil {
+			continue
+		}
+		wipePrivateKey(key.entity.PrivateKey)
+		for i := range key.entity.Subkeys {
+			wipePrivateKey(key.entity.Subkeys[i].PrivateKey)
+		}
+	}
+}
+
+func wipePrivateKey(privateKey *packet.PrivateKey) {
+	if privateKey == nil {
+		return
+	}
+
+	switch key := privateKey.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		wipeInt(key.D)
+		for _, prime := range key.Primes {
+			wipeInt(prime)
+		}
+		wipeInt(key.Precomputed.Dp)
+		wipeInt(key.Precomputed.Dq)
+		wipeInt(key.Precomputed.Qinv)
+	case *dsa.PrivateKey:
+		wipeInt(key.X)
+	case *elgamal.PrivateKey:
+		wipeInt(key.X)
+	case *ecdsa.PrivateKey:
+		wipeInt(key.D)
+	case *eddsa.PrivateKey:
+		secmem.Zero(key.D)
+	case *ecdh.PrivateKey:
+		secmem.Zero(key.D)
+	case *ed25519.PrivateKey:
+		secmem.Zero(key.Key)
+	case *ed448.PrivateKey:
+		secmem.Zero(key.Key)
+	case *x25519.PrivateKey:
+		secmem.Zero(key.Secret)
+	case *x448.PrivateKey:
+		secmem.Zero(key.Secret)
+	}
+
+	privateKey.PrivateKey = nil
+}
+
+func wipeInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	words := n.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	n.SetInt64(0)
+}
+
+// wiped reports whether WipePrivkey dropped any of the key's secret key material, which the OpenPGP library would otherwise use without checking.
+func (key *Key) wiped() bool {
+	if wipedPrivateKey(key.entity.PrivateKey) {
+		return true
+	}
+	for _, subkey := range key.entity.Subkeys {
+		if wipedPrivateKey(subkey.PrivateKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func wipedPrivateKey(privateKey *packet.PrivateKey) bool {
+	return privateKey != nil && !privateKey.Encrypted && !privateKey.Dummy() && privateKey.PrivateKey == nil
+}
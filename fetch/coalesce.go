@@ -0,0 +1,93 @@
+package fetch
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/parser"
+)
+
+const DefaultCoalesceWait = 10 * time.Second
+
+func coalesceWait(options FetchOptions) time.Duration {
+	if options.CoalesceWait > 0 {
+		return options.CoalesceWait
+	}
+	return DefaultCoalesceWait
+}
+
+// fetchCoalesced lets one process at a time fetch envkey into the cache. The others wait for its lock, then use the entry it cached if that was fetched within the coalesce wait. If the lock isn't released in time, or there's no usable entry once it is, they fetch as usual. Nobody waits while the last response fetched for envkey couldn't be cached.
+func fetchCoalesced(envkey string, options FetchOptions, policy sourcePolicy) (*FetchResult, error) {
+	envkeyParam, pw, _, free := splitEnvkey(envkey, options)
+	defer free()
+
+	fetchCache, err := newCache(options)
+	if err != nil {
+		if options.VerboseOutput {
+			fmt.Fprintf(os.Stderr, "Error initializing cache: %s\n", err.Error())
+		}
+		return fetchFresh(envkey, options, policy)
+	}
+
+	if !fetchCache.Coalescing(envkeyParam) {
+		return fetchUncoalesced(envkey, options, policy)
+	}
+
+	if options.VerboseOutput {
+		fmt.Fprintln(os.Stderr, "Waiting for any other process fetching the same ENVKEY...")
+	}
+
+	unlock, err := fetchCache.LockFetch(envkeyParam, coalesceWait(options))
+	if err != nil {
+		if options.VerboseOutput {
+			fmt.Fprintf(os.Stderr, "Not coalescing: %s\n", err.Error())
+		}
+		return fetchFresh(envkey, options, policy)
+	}
+
+	entry, err := fetchCache.Read(envkeyParam, pw)
+	if err == nil && time.Since(entry.FetchedAt) <= coalesceWait(options) {
+		// once the entry is read, the next waiter can have the lock while this one decrypts
+		unlock()
+
+		verifiedEnv, err := verifyCached(envkeyParam, entry.Body, pw, options)
+		if err != nil {
+			return fetchFresh(envkey, options, policy)
+		}
+		if options.VerboseOutput {
+			fmt.Fprintf(os.Stderr, "Loaded from cache, fetched %s ago by another process.\n", time.Since(entry.FetchedAt).Round(time.Millisecond))
+		}
+
+		result := policy.result(SourceCache, entry)
+		result.Json = verifiedEnv.Json
+		return result, nil
+	}
+
+	// the process that held the lock may have fetched a response that can't be cached
+	if !fetchCache.Coalescing(envkeyParam) {
+		unlock()
+		return fetchUncoalesced(envkey, options, policy)
+	}
+
+	// while the lock is held, others wait for this fetch
+	defer unlock()
+	return fetchFresh(envkey, options, policy)
+}
+
+func fetchUncoalesced(envkey string, options FetchOptions, policy sourcePolicy) (*FetchResult, error) {
+	if options.VerboseOutput {
+		fmt.Fprintln(os.Stderr, "Not coalescing: the last response fetched can't be cached")
+	}
+	return fetchFresh(envkey, options, policy)
+}
+
+// setCoalescing turns coalescing off for envkeyParam after a response that can't be cached, so other processes don't wait for an entry that won't be written, and back on after one that can.
+func setCoalescing(fetchCache *cache.Cache, envkeyParam string, response *parser.EnvServiceResponse, options FetchOptions) {
+	err := fetchCache.SetCoalescing(envkeyParam, response.AllowCaching)
+	if err != nil && options.VerboseOutput {
+		fmt.Fprintln(os.Stderr, "Error recording whether to coalesce:")
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,126 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/envkey/envkey-fetch/parser"
+)
+
+const (
+	CrossCheckPolicyFail = "fail"
+	CrossCheckPolicyWarn = "warn"
+)
+
+var ErrCrossCheckMismatch = errors.New("cross-check failed: primary and backup responses disagree")
+var ErrCrossCheckUnavailable = errors.New("cross-check failed: no backup response to compare with")
+
+type crossCheckResult struct {
+	response *parser.EnvServiceResponse
+	err      error
+}
+
+func validateCrossCheckPolicy(options FetchOptions) error {
+	switch options.CrossCheckPolicy {
+	case "", CrossCheckPolicyFail, CrossCheckPolicyWarn:
+		return nil
+	default:
+		return errors.New("unknown cross-check policy: " + options.CrossCheckPolicy)
+	}
+}
+
+// startCrossCheck loads the env from the backup urls in parallel with the primary request. Calling cancel stops the backup requests, e.g. once the primary request has failed. Envkeys with a custom host have no backups, so the channel is nil.
+func startCrossCheck(envkey string, options FetchOptions) (resultChan chan crossCheckResult, cancel context.CancelFunc) {
+	envkeyParam, envkeyHost := envkeyIdAndHost(envkey)
+	if envkeyHost != "" && envkeyHost != DefaultHost {
+		return nil, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resultChan = make(chan crossCheckResult, 1)
+
+	go func() {
+		// headers were already validated in Fetch
+		header, _ := requestHeader(options)
+		response := new(parser.EnvServiceResponse)
+		_, err := fetchBackup(ctx, envkeyParam, options, header, response)
+		resultChan <- crossCheckResult{response, err}
+	}()
+
+	return resultChan, cancel
+}
+
+// crossCheck verifies the backup response and compares it with the verified primary response. A disagreement fails unless the policy is "warn".
+// So does a response that can't be cross-checked: one loaded from somewhere other than the primary, one for a custom host, which has no backups, or one whose backup couldn't be loaded.
+func crossCheck(primary *parser.VerifiedEnv, source string, pw []byte, resultChan chan crossCheckResult, options FetchOptions) error {
+	var err error
+
+	if resultChan == nil {
+		err = fmt.Errorf("%w: custom hosts have no backups", ErrCrossCheckUnavailable)
+	} else if source != SourcePrimary {
+		err = fmt.Errorf("%w: response was loaded from %s", ErrCrossCheckUnavailable, source)
+	} else if result := <-resultChan; result.err != nil {
+		err = fmt.Errorf("%w: could not load from backup urls", ErrCrossCheckUnavailable)
+		if options.VerboseOutput {
+			fmt.Fprintln(os.Stderr, result.err)
+		}
+	} else {
+		err = compareBackup(primary, result.response, pw, options)
+	}
+
+	if err == nil {
+		if options.VerboseOutput {
+			fmt.Fprintln(os.Stderr, "Cross-check passed: primary and backup responses match.")
+		}
+		return nil
+	}
+
+	if options.CrossCheckPolicy == CrossCheckPolicyWarn {
+		fmt.Fprintln(os.Stderr, "Warning: "+err.Error())
+		return nil
+	}
+
+	return err
+}
+
+// compareBackup verifies the backup response and returns ErrCrossCheckMismatch if it isn't the same as the primary.
+func compareBackup(primary *parser.VerifiedEnv, response *parser.EnvServiceResponse, pw []byte, options FetchOptions) error {
+	var err error
+	backup, parseErr := response.ParseVerifiedWithPolicy(pw, options.TrustPolicy)
+	if parseErr != nil {
+		err = fmt.Errorf("%w: backup could not be verified: %v", ErrCrossCheckMismatch, parseErr)
+	} else if diffs := compareVerifiedEnvs(primary, backup); len(diffs) > 0 {
+		err = fmt.Errorf("%w: %s", ErrCrossCheckMismatch, strings.Join(diffs, ", "))
+	}
+	return err
+}
+
+// compareVerifiedEnvs lists what differs between two verified envs without including any values.
+func compareVerifiedEnvs(a, b *parser.VerifiedEnv) []string {
+	var diffs []string
+
+	if !jsonEqual(a.Json, b.Json) {
+		diffs = append(diffs, "env differs")
+	}
+	if a.SignerId != b.SignerId || a.SignerFingerprint != b.SignerFingerprint {
+		diffs = append(diffs, "signer differs")
+	}
+	if a.InheritanceOverridesSignerId != b.InheritanceOverridesSignerId || a.InheritanceOverridesSignerFingerprint != b.InheritanceOverridesSignerFingerprint {
+		diffs = append(diffs, "inheritance overrides signer differs")
+	}
+
+	return diffs
+}
+
+func jsonEqual(a, b string) bool {
+	var aVal, bVal interface{}
+	if json.Unmarshal([]byte(a), &aVal) != nil || json.Unmarshal([]byte(b), &bVal) != nil {
+		return a == b
+	}
+	return reflect.DeepEqual(aVal, bVal)
+}
@@ -0,0 +1,58 @@
+package fetch
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/envkey/envkey-fetch/parser"
+	"github.com/envkey/envkey-fetch/trust"
+)
+
+// DecryptResponse verifies and decrypts body, a raw response saved from the server, without loading anything. key is the ENVKEY the response was fetched with, or just its passphrase, in which case the trust policy's default applies. Known signers aren't checked or recorded.
+func DecryptResponse(body []byte, key string, options FetchOptions) (*parser.VerifiedEnv, error) {
+	response, envkey, trustPolicy, err := readResponse(body, key, options)
+	if err != nil {
+		return nil, err
+	}
+
+	_, pw, _, free := splitEnvkey(envkey, options)
+	defer free()
+
+	return response.ParseVerifiedWithPolicy(pw, trustPolicy)
+}
+
+// ExplainResponse is Explain for a saved response body, taking the same arguments as DecryptResponse.
+func ExplainResponse(body []byte, key string, options FetchOptions) (*parser.Explanation, error) {
+	response, envkey, trustPolicy, err := readResponse(body, key, options)
+	if err != nil {
+		return nil, err
+	}
+
+	_, pw, _, free := splitEnvkey(envkey, options)
+	defer free()
+
+	return response.Explain(pw, trustPolicy), nil
+}
+
+// readResponse decodes body and loads the trust policy for key. A passphrase alone is returned as an ENVKEY with an empty id.
+func readResponse(body []byte, key string, options FetchOptions) (*parser.EnvServiceResponse, string, *trust.Policy, error) {
+	envkey := key
+	if !strings.Contains(key, "-") {
+		envkey = "-" + key
+	}
+	if strings.Split(envkey, "-")[1] == "" {
+		return nil, "", nil, errors.New("ENVKEY invalid")
+	}
+
+	trustPolicy, err := LoadTrustPolicy(envkey, options)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	response, err := DecodeResponse(body, options)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return response, envkey, trustPolicy, nil
+}
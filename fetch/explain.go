@@ -0,0 +1,36 @@
+package fetch
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/parser"
+)
+
+// Explain loads envkey's response from the same sources Fetch would, then describes each step of verifying it instead of returning the env. Nothing is written to the cache.
+func Explain(envkey string, options FetchOptions) (*parser.Explanation, *FetchResult, error) {
+	options, policy, err := prepare(envkey, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fetchCache *cache.Cache
+	if options.ShouldCache {
+		var cacheErr error
+		fetchCache, cacheErr = newCache(options)
+		if options.VerboseOutput && cacheErr != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing cache: %s\n", cacheErr.Error())
+		}
+	}
+
+	envkeyParam, pw, envkeyHost, free := splitEnvkey(envkey, options)
+	defer free()
+
+	response, result, err := fetchEnv(envkeyParam, pw, envkeyHost, options, policy, fetchCache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return response.Explain(pw, options.TrustPolicy), result, nil
+}
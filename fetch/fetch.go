@@ -3,45 +3,107 @@ package fetch
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"math"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/certifi/gocertifi"
 	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/internal/secmem"
 	"github.com/envkey/envkey-fetch/parser"
+	"github.com/envkey/envkey-fetch/trust"
 	"github.com/envkey/envkey-fetch/version"
 	multierror "github.com/hashicorp/go-multierror"
 )
 
 type FetchOptions struct {
-	ShouldCache    bool
-	CacheDir       string
-	ClientName     string
-	ClientVersion  string
-	VerboseOutput  bool
-	TimeoutSeconds float64
-	Retries        uint8
-	RetryBackoff   float64
+	ShouldCache          bool
+	CacheDir             string
+	CacheStore           cache.Store
+	CacheGenerations     int
+	ClientName           string
+	ClientVersion        string
+	VerboseOutput        bool
+	TimeoutSeconds       float64
+	Retries              uint8
+	RetryBackoff         float64
+	Pins                 []string
+	PinReportOnly        bool
+	CAStrategy           string
+	CAFile               string
+	Proxy                string
+	ProxyCredentialsFile string
+	Headers              []string
+	MetadataHeaders      bool
+	Resolve              []string
+	DNSServer            string
+	MaxBodyBytes         int64
+	StrictResponse       bool
+	CrossCheck           bool
+	CrossCheckPolicy     string
+	PreferCache          bool
+	CacheMaxAge          time.Duration
+	RefreshTimeout       time.Duration
+	Offline              bool
+	NoBackup             bool
+	CacheOnlyOnOutage    bool
+	MaxStale             time.Duration
+	RequireFresh         bool
+	Rollback             int
+	ReplayPolicy         string
+	Coalesce             bool
+	CoalesceWait         time.Duration
+	TrustPolicy          *trust.Policy
+	TrustPolicyFile      string
+	KnownSigners         string
+	KnownSignersFile     string
+	LockMemory           bool
+
+	// built by prepare from the options above, unless Client overrides it
+	client *http.Client
 }
 
+// FetchResult is a verified env along with where it was loaded from. Generation is only set for the cache, where 0 is the latest.
+type FetchResult struct {
+	Json           string
+	Source         string
+	SourceUrl      string
+	FetchedAt      time.Time
+	Generation     int
+	AllowedSources []string
+
+	entry *cache.Entry
+}
+
+// Where a response was loaded from
+const (
+	SourcePrimary = "primary"
+	SourceBackup  = "backup"
+	SourceCache   = "cache"
+)
+
 var DefaultHost = "env.envkey.com"
 var BackupHost = "s3-eu-west-1.amazonaws.com/envkey-backup/envs"
 var BackupHostRestricted = "me66hg5t17.execute-api.eu-west-1.amazonaws.com/default/envBackup"
 var ApiVersion = 1
 
+// Client, if set, is used for every request instead of a client built from each call's FetchOptions, e.g. to mock responses in tests. InitHttpClient and InitHttpClientWithOptions set it.
 var Client *http.Client
 
+// lastClient is reused by calls with the same client options, so connections are kept alive between them.
+var lastClient struct {
+	sync.Mutex
+	key    string
+	client *http.Client
+}
+
 type httpChannelResponse struct {
 	response *http.Response
 	url      string
@@ -53,70 +115,235 @@ type httpChannelErr struct {
 }
 
 func Fetch(envkey string, options FetchOptions) (string, error) {
+	return FetchWithRefresh(envkey, options, nil)
+}
+
+// FetchWithResult is Fetch, returning where the env was loaded from as well.
+func FetchWithResult(envkey string, options FetchOptions) (*FetchResult, error) {
+	return fetchWithRefresh(envkey, options, nil)
+}
+
+// FetchWithRefresh is Fetch with a callback for the result of fetching from the network. With PreferCache set, a valid cache entry younger than CacheMaxAge is returned right away and the cache is refreshed in the background, after which onRefresh is called with the fresh result.
+// Otherwise, onRefresh is called with the same result FetchWithRefresh returns, before it returns. onRefresh may be nil.
+func FetchWithRefresh(envkey string, options FetchOptions, onRefresh RefreshFunc) (string, error) {
+	result, err := fetchWithRefresh(envkey, options, onRefresh)
+	if err != nil {
+		return "", err
+	}
+	return result.Json, nil
+}
+
+func fetchWithRefresh(envkey string, options FetchOptions, onRefresh RefreshFunc) (*FetchResult, error) {
+	options, policy, err := prepare(envkey, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.PreferCache {
+		result, ok := fetchPreferCache(envkey, options, policy, onRefresh)
+		if ok {
+			return result, nil
+		}
+	}
+
+	var result *FetchResult
+	if options.Coalesce {
+		result, err = fetchCoalesced(envkey, options, policy)
+	} else {
+		result, err = fetchFresh(envkey, options, policy)
+	}
+
+	if onRefresh != nil {
+		if err != nil {
+			onRefresh("", err)
+		} else {
+			onRefresh(result.Json, nil)
+		}
+	}
+	return result, err
+}
+
+// prepare validates envkey and options, applies the options others imply, and sets up the http client if the network may be used.
+func prepare(envkey string, options FetchOptions) (FetchOptions, sourcePolicy, error) {
 	if len(strings.Split(envkey, "-")) < 2 {
-		return "", errors.New("ENVKEY invalid")
+		return options, sourcePolicy{}, errors.New("ENVKEY invalid")
 	}
 
-	// may be initalized already when mocking for tests
-	if Client == nil {
-		InitHttpClient(options.TimeoutSeconds)
+	// validate headers up front rather than on every request
+	if _, err := requestHeader(options); err != nil {
+		return options, sourcePolicy{}, err
 	}
 
-	var fetchCache *cache.Cache
-	var cacheErr error
+	if err := validateCrossCheckPolicy(options); err != nil {
+		return options, sourcePolicy{}, err
+	}
 
-	if options.ShouldCache {
-		if options.VerboseOutput {
-			var cachePath string
-			if options.CacheDir == "" {
-				cachePath, _ = cache.DefaultPath()
-			} else {
-				cachePath = options.CacheDir
-			}
-			fmt.Fprintf(os.Stderr, "Initializing cache at %s\n", cachePath)
+	if err := validateReplayPolicy(options); err != nil {
+		return options, sourcePolicy{}, err
+	}
+
+	if err := validateKnownSignersPolicy(options); err != nil {
+		return options, sourcePolicy{}, err
+	}
+
+	if options.PreferCache || options.Offline || options.Rollback > 0 || options.Coalesce {
+		options.ShouldCache = true
+	}
+
+	if options.LockMemory {
+		// check once that locking works, rather than warning about every buffer
+		_, free, err := secmem.Copy("-", true)
+		free()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: not locking memory: "+err.Error())
+			options.LockMemory = false
+		}
+	}
+
+	policy, err := newSourcePolicy(options)
+	if err != nil {
+		return options, sourcePolicy{}, err
+	}
+
+	options.TrustPolicy, err = LoadTrustPolicy(envkey, options)
+	if err != nil {
+		return options, sourcePolicy{}, err
+	}
+
+	if options.VerboseOutput {
+		fmt.Fprintf(os.Stderr, "Allowed sources: %s\n", strings.Join(policy.sources(), ", "))
+	}
+
+	if policy.network {
+		options.client, err = httpClient(options)
+		if err != nil {
+			return options, sourcePolicy{}, err
 		}
+	}
+
+	return options, policy, nil
+}
 
+func fetchFresh(envkey string, options FetchOptions, policy sourcePolicy) (*FetchResult, error) {
+	var fetchCache *cache.Cache
+	var cacheErr error
+
+	if options.ShouldCache || replayProtected(options) {
 		// If initializing cache fails for some reason, ignore and let it be nil
-		fetchCache, cacheErr = cache.NewCache(options.CacheDir)
+		fetchCache, cacheErr = newCache(options)
 
 		if options.VerboseOutput && cacheErr != nil {
 			fmt.Fprintf(os.Stderr, "Error initializing cache: %s\n", cacheErr.Error())
 		}
 	}
 
-	response, envkeyParam, pw, err := fetchEnv(envkey, options, fetchCache)
+	var crossCheckChan chan crossCheckResult
+	if options.CrossCheck {
+		var cancelCrossCheck context.CancelFunc
+		crossCheckChan, cancelCrossCheck = startCrossCheck(envkey, options)
+		defer cancelCrossCheck()
+	}
+
+	envkeyParam, pw, envkeyHost, free := splitEnvkey(envkey, options)
+	defer free()
+
+	response, result, err := fetchEnv(envkeyParam, pw, envkeyHost, options, policy, fetchCache)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if options.VerboseOutput {
 		fmt.Fprintln(os.Stderr, "Parsing and decrypting response...")
 	}
-	res, err := response.Parse(pw)
+	verifiedEnv, err := response.ParseVerifiedWithPolicy(pw, options.TrustPolicy)
 	if err != nil {
 		if options.VerboseOutput {
 			fmt.Fprintln(os.Stderr, "Error parsing and decrypting:")
 			fmt.Fprintln(os.Stderr, err)
 		}
 
-		if fetchCache != nil {
-			fetchCache.Delete(envkeyParam)
+		// the cache is left alone, so an invalid push can't wipe out earlier generations
+		if errors.Is(err, trust.ErrPolicy) {
+			return nil, err
 		}
-		return "", errors.New("ENVKEY invalid")
+		return nil, errors.New("ENVKEY invalid")
 	}
 
-	// Ensure cache bizness finished (don't worry about error)
-	if fetchCache != nil {
-		select {
-		case <-fetchCache.Done:
-		default:
+	if options.CrossCheck {
+		err = crossCheck(verifiedEnv, result.Source, pw, crossCheckChan, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fresh := result.Source != SourceCache
+
+	if fresh && replayProtected(options) {
+		if fetchCache == nil {
+			return nil, fmt.Errorf("replay protection needs the cache: %v", cacheErr)
+		}
+		err = checkReplay(fetchCache, envkeyParam, pw, response, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = checkKnownSigners(envkeyParam, verifiedEnv, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// only verified responses are cached
+	if fetchCache != nil && options.ShouldCache && fresh && response.AllowCaching {
+		writeCache(fetchCache, envkeyParam, pw, result.entry, options)
+	}
+
+	if fetchCache != nil && options.Coalesce && fresh {
+		setCoalescing(fetchCache, envkeyParam, response, options)
+	}
+
+	result.Json = verifiedEnv.Json
+	return result, nil
+}
+
+// newCache keeps the cache in options.CacheStore if it's set, otherwise in files in options.CacheDir.
+func newCache(options FetchOptions) (*cache.Cache, error) {
+	var fetchCache *cache.Cache
+	var err error
+
+	if options.CacheStore != nil {
+		fetchCache = cache.NewCacheWithStore(options.CacheStore)
+	} else {
+		fetchCache, err = cache.NewCache(options.CacheDir)
+		if err != nil {
+			return nil, err
 		}
 	}
+	fetchCache.Generations = options.CacheGenerations
 
-	return res, nil
+	if options.VerboseOutput {
+		if fetchCache.Dir != "" {
+			fmt.Fprintf(os.Stderr, "Initializing cache at %s\n", fetchCache.Dir)
+		} else {
+			fmt.Fprintf(os.Stderr, "Initializing cache in %T\n", options.CacheStore)
+		}
+	}
+
+	return fetchCache, nil
 }
 
-func UrlWithLoggingParams(baseUrl string, options FetchOptions) string {
+// A failed cache write doesn't fail the fetch. An insecure cache directory is always reported, since it means the cache won't be used until it's fixed.
+func writeCache(fetchCache *cache.Cache, envkeyParam string, pw []byte, entry *cache.Entry, options FetchOptions) {
+	err := fetchCache.Write(envkeyParam, pw, entry)
+	if errors.Is(err, cache.ErrInsecure) {
+		fmt.Fprintln(os.Stderr, "Warning: not caching: "+err.Error())
+	} else if err != nil && options.VerboseOutput {
+		fmt.Fprintln(os.Stderr, "Error writing cache:")
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func clientMetadata(options FetchOptions) (string, string) {
 	clientName := options.ClientName
 	if clientName == "" {
 		clientName = "envkey-fetch"
@@ -127,6 +354,12 @@ func UrlWithLoggingParams(baseUrl string, options FetchOptions) string {
 		clientVersion = version.Version
 	}
 
+	return clientName, clientVersion
+}
+
+func UrlWithLoggingParams(baseUrl string, options FetchOptions) string {
+	clientName, clientVersion := clientMetadata(options)
+
 	var querySep string
 	if strings.Contains(baseUrl, "?") {
 		querySep = "&"
@@ -146,46 +379,113 @@ func UrlWithLoggingParams(baseUrl string, options FetchOptions) string {
 	)
 }
 
-func InitHttpClient(timeoutSeconds float64) {
-	to := time.Second * time.Duration(timeoutSeconds)
-	Client = &http.Client{
+// InitHttpClient sets Client to a client with default options. It only fails if no root certificates can be loaded.
+func InitHttpClient(timeoutSeconds float64) error {
+	return InitHttpClientWithOptions(FetchOptions{TimeoutSeconds: timeoutSeconds})
+}
+
+// InitHttpClientWithOptions sets Client to a client built from options, which is then used for every request whatever options they're made with.
+func InitHttpClientWithOptions(options FetchOptions) error {
+	client, err := newHttpClient(options)
+	if err != nil {
+		return err
+	}
+	Client = client
+	return nil
+}
+
+// httpClient returns Client if it's set. Otherwise it returns a client built from options, reusing the last one if it was built from the same options.
+func httpClient(options FetchOptions) (*http.Client, error) {
+	if Client != nil {
+		return Client, nil
+	}
+
+	key := clientKey(options)
+
+	lastClient.Lock()
+	defer lastClient.Unlock()
+	if lastClient.client != nil && lastClient.key == key {
+		return lastClient.client, nil
+	}
+
+	client, err := newHttpClient(options)
+	if err != nil {
+		return nil, err
+	}
+	lastClient.key, lastClient.client = key, client
+	return client, nil
+}
+
+// clientKey covers every option newHttpClient uses.
+func clientKey(options FetchOptions) string {
+	return fmt.Sprintf("%v %q %v %q %q %q %q %q %q %v",
+		options.TimeoutSeconds,
+		options.Pins,
+		options.PinReportOnly,
+		options.CAStrategy,
+		options.CAFile,
+		options.Proxy,
+		options.ProxyCredentialsFile,
+		options.Resolve,
+		options.DNSServer,
+		options.VerboseOutput,
+	)
+}
+
+func newHttpClient(options FetchOptions) (*http.Client, error) {
+	pins, err := parsePins(options.Pins)
+	if err != nil {
+		return nil, err
+	}
+
+	rootCAs, err := loadRootCAs(options)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := proxyFunc(options)
+	if err != nil {
+		return nil, err
+	}
+
+	dial, err := dialContext(options)
+	if err != nil {
+		return nil, err
+	}
+
+	to := time.Second * time.Duration(options.TimeoutSeconds)
+	return &http.Client{
 		Timeout: to,
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			Dial: (&net.Dialer{
-				Timeout: time.Duration(timeoutSeconds) * time.Second,
-			}).Dial,
-			TLSHandshakeTimeout: time.Duration(timeoutSeconds) * time.Second,
+			Proxy:               proxy,
+			DialContext:         dial,
+			TLSHandshakeTimeout: time.Duration(options.TimeoutSeconds) * time.Second,
+			TLSClientConfig: &tls.Config{
+				RootCAs:          rootCAs,
+				VerifyConnection: pinVerifier(pins, options),
+			},
 		},
-	}
+	}, nil
 }
 
 func httpExecRequest(
+	client *http.Client,
 	req *http.Request,
 	respChan chan httpChannelResponse,
 	errChan chan httpChannelErr,
 ) {
-	resp, err := Client.Do(req)
+	resp, err := client.Do(req)
 	if err == nil {
 		respChan <- httpChannelResponse{resp, req.URL.String()}
 	} else {
-		// if error caused by missing root certificates, pull in gocertifi certs (which come from Mozilla) and try again with those
-		if strings.Contains(err.Error(), "x509: failed to load system roots") {
-			certPool, certPoolErr := gocertifi.CACerts()
-			if certPoolErr != nil {
-				errChan <- httpChannelErr{multierror.Append(err, certPoolErr), req.URL.String()}
-				return
-			}
-			Client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: certPool}
-			httpExecRequest(req, respChan, errChan)
-		} else {
-			errChan <- httpChannelErr{err, req.URL.String()}
-		}
+		errChan <- httpChannelErr{err, req.URL.String()}
 	}
 }
 
 func httpGetAsync(
+	client *http.Client,
 	url string,
+	header http.Header,
 	ctx context.Context,
 	respChan chan httpChannelResponse,
 	errChan chan httpChannelErr,
@@ -198,14 +498,17 @@ func httpGetAsync(
 	}
 
 	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
 
-	go httpExecRequest(req, respChan, errChan)
+	go httpExecRequest(client, req, respChan, errChan)
 }
 
-func httpGet(url string) (*http.Response, error) {
+func httpGet(client *http.Client, url string, header http.Header) (*http.Response, error) {
 	respChan, errChan := make(chan httpChannelResponse), make(chan httpChannelErr)
 
-	httpGetAsync(url, context.Background(), respChan, errChan)
+	httpGetAsync(client, url, header, context.Background(), respChan, errChan)
 
 	for {
 		select {
@@ -232,12 +535,11 @@ func logRequestIfVerbose(url string, options FetchOptions, err error, r *http.Re
 	}
 }
 
-func fetchEnv(envkey string, options FetchOptions, fetchCache *cache.Cache) (*parser.EnvServiceResponse, string, string, error) {
-	envkeyParam, pw, envkeyHost := splitEnvkey(envkey)
+func fetchEnv(envkeyParam string, pw []byte, envkeyHost string, options FetchOptions, policy sourcePolicy, fetchCache *cache.Cache) (*parser.EnvServiceResponse, *FetchResult, error) {
 	response := new(parser.EnvServiceResponse)
-	err := getJson(envkeyHost, envkeyParam, options, response, fetchCache)
+	result, err := getJson(envkeyHost, envkeyParam, pw, options, policy, response, fetchCache)
 
-	if err != nil && options.Retries > 0 {
+	if err != nil && options.Retries > 0 && policy.network {
 		var retry uint8 = 0
 		for retry < options.Retries {
 			if options.RetryBackoff > 0 {
@@ -251,7 +553,7 @@ func fetchEnv(envkey string, options FetchOptions, fetchCache *cache.Cache) (*pa
 			if options.VerboseOutput {
 				fmt.Fprintf(os.Stderr, "\nRetrying...\n")
 			}
-			err = getJson(envkeyHost, envkeyParam, options, response, fetchCache)
+			result, err = getJson(envkeyHost, envkeyParam, pw, options, policy, response, fetchCache)
 			if err == nil {
 				break
 			}
@@ -261,20 +563,25 @@ func fetchEnv(envkey string, options FetchOptions, fetchCache *cache.Cache) (*pa
 
 	}
 
-	return response, envkeyParam, pw, err
+	return response, result, err
+}
+
+// splitEnvkey splits envkey into its id, passphrase and host. The passphrase is copied into a buffer that free wipes, which is locked into memory if options.LockMemory is set.
+func splitEnvkey(envkey string, options FetchOptions) (envkeyParam string, pw []byte, envkeyHost string, free func()) {
+	envkeyParam, envkeyHost = envkeyIdAndHost(envkey)
+
+	// a buffer that couldn't be locked is still wiped, and prepare has already warned that locking doesn't work
+	pw, free, _ = secmem.Copy(strings.Split(envkey, "-")[1], options.LockMemory)
+	return envkeyParam, pw, envkeyHost, free
 }
 
-func splitEnvkey(envkey string) (string, string, string) {
+// envkeyIdAndHost returns the parts of envkey that aren't secret.
+func envkeyIdAndHost(envkey string) (envkeyParam, envkeyHost string) {
 	split := strings.Split(envkey, "-")
-	var envkeyParam, pw, envkeyHost string
 	if len(split) > 2 {
-		envkeyParam, pw, envkeyHost = split[0], split[1], strings.Join(split[2:], "-")
-	} else {
-		envkeyParam, pw = split[0], split[1]
-		envkeyHost = ""
+		return split[0], strings.Join(split[2:], "-")
 	}
-
-	return envkeyParam, pw, envkeyHost
+	return split[0], ""
 }
 
 func getBaseUrl(envkeyHost string, envkeyParam string) string {
@@ -299,7 +606,7 @@ func getBaseUrl(envkeyHost string, envkeyParam string) string {
 
 func getJsonUrl(envkeyHost string, envkeyParam string, options FetchOptions) string {
 	baseUrl := getBaseUrl(envkeyHost, envkeyParam)
-	return UrlWithLoggingParams(baseUrl, options)
+	return requestUrl(baseUrl, options)
 }
 
 func getBackupUrls(envkeyParam string) []string {
@@ -311,126 +618,217 @@ func getBackupUrls(envkeyParam string) []string {
 	}
 }
 
-func fetchBackup(envkeyParam string, options FetchOptions) (*http.Response, error) {
+func fetchBackup(ctx context.Context, envkeyParam string, options FetchOptions, header http.Header, response *parser.EnvServiceResponse) (*cache.Entry, error) {
 	backupUrls := getBackupUrls(envkeyParam)
 
 	if options.VerboseOutput {
 		fmt.Fprintf(os.Stderr, "Attempting to load encrypted config from backup urls: %s\n", backupUrls)
 	}
 
-	respChan, errChan := make(chan httpChannelResponse), make(chan httpChannelErr)
+	// buffered so requests still in flight after we return don't block forever
+	respChan, errChan := make(chan httpChannelResponse, len(backupUrls)), make(chan httpChannelErr, len(backupUrls))
 
-	cancelFnByUrl := map[string]context.CancelFunc{}
+	cancelFns := []context.CancelFunc{}
+	pending := len(backupUrls)
+	defer func() {
+		for _, cancel := range cancelFns {
+			cancel()
+		}
+
+		// wait for cancelled requests so none outlive the fetch
+		for ; pending > 0; pending-- {
+			select {
+			case channelResp := <-respChan:
+				channelResp.response.Body.Close()
+			case <-errChan:
+			}
+		}
+	}()
 
 	for _, backupUrl := range backupUrls {
-		ctx, cancel := context.WithCancel(context.Background())
-		urlWithParams := UrlWithLoggingParams(backupUrl, options)
-		cancelFnByUrl[urlWithParams] = cancel
-		httpGetAsync(urlWithParams, ctx, respChan, errChan)
+		reqCtx, cancel := context.WithCancel(ctx)
+		cancelFns = append(cancelFns, cancel)
+		httpGetAsync(options.client, requestUrl(backupUrl, options), header, reqCtx, respChan, errChan)
 	}
 
+	// the first valid response wins; invalid responses count as errors so the other backup still gets a chance
 	var err error
-	numErrs := 0
-	for {
+	for pending > 0 {
 		select {
 		case channelResp := <-respChan:
-			logRequestIfVerbose(channelResp.url, options, nil, channelResp.response)
+			pending--
+			entry, respErr := readResponseBody(channelResp.response, channelResp.url, options, response)
+			channelResp.response.Body.Close()
+			logRequestIfVerbose(channelResp.url, options, respErr, channelResp.response)
 
-			// cancel other requests
-			for backupUrl, cancel := range cancelFnByUrl {
-				if backupUrl != channelResp.url {
-					cancel()
-				}
+			if respErr == nil {
+				return entry, nil
 			}
-
-			return channelResp.response, nil
+			err = multierror.Append(err, respErr)
 		case channelErr := <-errChan:
+			pending--
+			logRequestIfVerbose(channelErr.url, options, channelErr.err, nil)
 			err = multierror.Append(err, channelErr.err)
-			numErrs++
-			if numErrs == len(backupUrls) {
-				logRequestIfVerbose(channelErr.url, options, channelErr.err, nil)
-				return nil, err
-			}
 		}
 	}
+
+	return nil, err
 }
 
-func getJson(envkeyHost string, envkeyParam string, options FetchOptions, response *parser.EnvServiceResponse, fetchCache *cache.Cache) error {
-	var err, fetchErr, backupFetchErr error
-	var body []byte
-	var r *http.Response
+func fetchPrimary(url string, options FetchOptions, header http.Header, response *parser.EnvServiceResponse) (*cache.Entry, error) {
+	r, err := httpGet(options.client, url, header)
+	if err != nil {
+		logRequestIfVerbose(url, options, err, nil)
+		return nil, err
+	}
+	defer r.Body.Close()
 
-	url := getJsonUrl(envkeyHost, envkeyParam, options)
+	entry, err := readResponseBody(r, url, options, response)
+	logRequestIfVerbose(url, options, err, r)
+	return entry, err
+}
 
-	r, fetchErr = httpGet(url)
-	if r != nil {
-		defer r.Body.Close()
+// shouldFetchBackup is true for outages on the default host. Other 4xx statuses go straight to the cache.
+func shouldFetchBackup(envkeyHost string, fetchErr error) bool {
+	if envkeyHost != "" && envkeyHost != DefaultHost {
+		return false
 	}
 
-	if options.VerboseOutput {
-		fmt.Fprintf(os.Stderr, "Attempting to load encrypted config from default url: %s\n", url)
+	return isOutage(fetchErr)
+}
+
+// isOutage is true for network errors, 5xx statuses and invalid responses.
+func isOutage(fetchErr error) bool {
+	var statusErr *StatusError
+	if errors.As(fetchErr, &statusErr) {
+		return statusErr.StatusCode >= 500
 	}
 
-	// If http request failed and we're using the default host, now try backup hosts
-	if fetchErr != nil || r.StatusCode >= 500 {
-		logRequestIfVerbose(url, options, fetchErr, r)
+	return true
+}
 
-		if envkeyHost == "" || envkeyHost == DefaultHost {
-			r, backupFetchErr = fetchBackup(envkeyParam, options)
+func getJson(envkeyHost string, envkeyParam string, pw []byte, options FetchOptions, policy sourcePolicy, response *parser.EnvServiceResponse, fetchCache *cache.Cache) (*FetchResult, error) {
+	var entry *cache.Entry
+	var fetchErr, backupFetchErr error
 
-			if r != nil {
-				defer r.Body.Close()
-			}
+	if policy.network {
+		header, err := requestHeader(options)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	if backupFetchErr == nil && (r != nil && r.StatusCode == 200) {
-		body, err = ioutil.ReadAll(r.Body)
+		url := getJsonUrl(envkeyHost, envkeyParam, options)
 
-		if err != nil {
-			if options.VerboseOutput {
-				fmt.Fprintln(os.Stderr, "Error reading response body:")
-				fmt.Fprintln(os.Stderr, err)
+		if options.VerboseOutput {
+			fmt.Fprintf(os.Stderr, "Attempting to load encrypted config from default url: %s\n", url)
+		}
+
+		source := SourcePrimary
+		entry, fetchErr = fetchPrimary(url, options, header, response)
+
+		// If http request failed or returned an invalid response and we're using the default host, now try backup hosts
+		if fetchErr != nil && !errors.Is(fetchErr, ErrNotFound) && shouldFetchBackup(envkeyHost, fetchErr) {
+			if policy.backup {
+				entry, backupFetchErr = fetchBackup(context.Background(), envkeyParam, options, header, response)
+				if backupFetchErr == nil {
+					source, fetchErr = SourceBackup, nil
+				}
+			} else if options.VerboseOutput {
+				fmt.Fprintln(os.Stderr, "Not loading from backup urls: backups disabled.")
 			}
-			return err
 		}
-	} else if backupFetchErr != nil || (r != nil && r.StatusCode >= 400 && r.StatusCode != 404) {
-		// try loading from cache
-		if fetchCache == nil {
-			if backupFetchErr == nil {
-				return errors.New("could not load from server or s3 backup.")
-			} else {
-				return errors.New("could not load from server or s3 backup.\nfetch error: " + fetchErr.Error() + "\nbackup fetch error: " + backupFetchErr.Error())
+
+		if fetchErr == nil {
+			return policy.result(source, entry), nil
+		}
+
+		// a single backup's 404 could be a stale or broken mirror, so only an answer all backups agree on counts
+		if errors.Is(fetchErr, ErrNotFound) || allNotFound(backupFetchErr) {
+			if options.VerboseOutput {
+				fmt.Fprintln(os.Stderr, "Fetch error.")
+				fmt.Fprintln(os.Stderr, "404 not found")
 			}
-		} else {
-			body, err = fetchCache.Read(envkeyParam)
-			if err != nil {
-				if options.VerboseOutput {
-					fmt.Fprintln(os.Stderr, "Cache read error:")
-					fmt.Fprintln(os.Stderr, err)
-				}
-				return errors.New("could not load from server, s3 backup, or cache.\nfetch error: " + fetchErr.Error() + "\nbackup fetch error: " + backupFetchErr.Error() + "\ncache read error: " + err.Error())
+
+			// Since envkey wasn't found and permission may have been removed, clear cache
+			if fetchCache != nil {
+				fetchCache.Delete(envkeyParam)
 			}
+			return nil, errors.New("ENVKEY invalid")
 		}
+	}
 
-	} else if r != nil && r.StatusCode == 404 {
+	msg := "could not load from " + policy.describe() + "."
+
+	// try loading from cache
+	if fetchCache == nil || !policy.cache {
+		return nil, loadError(msg, fetchErr, backupFetchErr, nil)
+	}
+
+	err := policy.checkCacheFallback(fetchErr)
+	if err == nil {
+		entry, err = fetchCache.ReadGeneration(envkeyParam, pw, policy.rollback)
+	}
+	if err == nil {
+		err = policy.checkCacheAge(entry)
+	}
+	if err == nil {
+		err = decodeResponse(entry.Body, false, response)
+	}
+	if err != nil {
 		if options.VerboseOutput {
-			fmt.Fprintln(os.Stderr, "Fetch error.")
-			fmt.Fprintln(os.Stderr, "404 not found")
+			fmt.Fprintln(os.Stderr, "Cache read error:")
+			fmt.Fprintln(os.Stderr, err)
 		}
+		return nil, loadError(msg, fetchErr, backupFetchErr, err)
+	}
+
+	if options.VerboseOutput {
+		fmt.Fprintf(os.Stderr, "Loaded generation %d from cache (fetched at %s).\n", policy.rollback, entry.FetchedAt.Format(time.RFC3339))
+	}
+
+	result := policy.result(SourceCache, entry)
+	result.Generation = policy.rollback
+	return result, nil
+}
 
-		// Since envkey wasn't found and permission may have been removed, clear cache
-		if fetchCache != nil {
-			fetchCache.Delete(envkeyParam)
+// allNotFound reports whether err is ErrNotFound or a multierror whose errors all are, as fetchBackup returns when every backup url 404s.
+func allNotFound(err error) bool {
+	merr, ok := err.(*multierror.Error)
+	if !ok {
+		return errors.Is(err, ErrNotFound)
+	}
+	if len(merr.Errors) == 0 {
+		return false
+	}
+	for _, err := range merr.Errors {
+		if !errors.Is(err, ErrNotFound) {
+			return false
 		}
-		return errors.New("ENVKEY invalid")
 	}
+	return true
+}
 
-	err = json.Unmarshal(body, response)
-	if fetchCache != nil && response.AllowCaching {
-		// If caching enabled, write raw response to cache while doing decryption in parallel
-		go fetchCache.Write(envkeyParam, body)
+// loadError combines the error from each source that was tried. errors.Is and errors.As see through to each of them.
+func loadError(msg string, fetchErr, backupFetchErr, cacheErr error) error {
+	merr := &multierror.Error{
+		ErrorFormat: func(errs []error) string {
+			lines := []string{msg}
+			for _, err := range errs {
+				lines = append(lines, err.Error())
+			}
+			return strings.Join(lines, "\n")
+		},
+	}
+
+	if fetchErr != nil {
+		merr = multierror.Append(merr, fmt.Errorf("fetch error: %w", fetchErr))
+	}
+	if backupFetchErr != nil {
+		merr = multierror.Append(merr, fmt.Errorf("backup fetch error: %w", backupFetchErr))
+	}
+	if cacheErr != nil {
+		merr = multierror.Append(merr, fmt.Errorf("cache read error: %w", cacheErr))
 	}
 
-	return err
+	return merr
 }
@@ -0,0 +1,110 @@
+package fetch_test
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesce(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := org.Response(map[string]string{"GO_TEST": "coalesced"}, true)
+
+	c, _ := cache.NewCache(testCacheDir)
+	defer c.Delete("validkey")
+
+	var calls int32
+	slow := func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(200 * time.Millisecond)
+		return httpmock.NewBytesResponse(http.StatusOK, body), nil
+	}
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, CacheDir: testCacheDir, NoBackup: true, Coalesce: true, CoalesceWait: 5 * time.Second}
+	registerResponders(slow, contentTypeResponder(http.StatusServiceUnavailable, "", ""), opts)
+
+	t.Run("concurrent fetches share one request", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := fetch.Fetch("validkey-anypassphrase", opts)
+				assert.Nil(t, err)
+				assert.Equal(t, `{"GO_TEST":"coalesced"}`, res)
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("an entry older than the wait is fetched again", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		c.Write("validkey", []byte("anypassphrase"), &cache.Entry{FetchedAt: time.Now().Add(-time.Minute), Body: body})
+
+		_, err := fetch.Fetch("validkey-anypassphrase", opts)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("a lock that's never released times out", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		c.Write("validkey", []byte("anypassphrase"), &cache.Entry{FetchedAt: time.Now().Add(-time.Minute), Body: body})
+
+		unlock, err := c.LockFetch("validkey", time.Second)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer unlock()
+
+		waitOpts := opts
+		waitOpts.CoalesceWait = 100 * time.Millisecond
+		_, err = fetch.Fetch("validkey-anypassphrase", waitOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Should fetch once the wait is over.")
+	})
+	t.Run("a response that can't be cached isn't waited for", func(t *testing.T) {
+		c.Delete("validkey")
+		defer c.SetCoalescing("validkey", true)
+
+		noCacheBody, _ := org.Response(map[string]string{"GO_TEST": "coalesced"}, false)
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, noCacheBody), contentTypeResponder(http.StatusServiceUnavailable, "", ""), opts)
+		_, err := fetch.Fetch("validkey-anypassphrase", opts)
+		assert.Nil(t, err)
+		assert.False(t, c.Coalescing("validkey"))
+
+		unlock, err := c.LockFetch("validkey", time.Second)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		start := time.Now()
+		_, err = fetch.Fetch("validkey-anypassphrase", opts)
+		assert.Nil(t, err)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second), "Should fetch without waiting for the lock.")
+
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, body), contentTypeResponder(http.StatusServiceUnavailable, "", ""), opts)
+		unlock()
+		_, err = fetch.Fetch("validkey-anypassphrase", opts)
+		assert.Nil(t, err)
+		assert.True(t, c.Coalescing("validkey"), "Should coalesce again once a response can be cached.")
+	})
+}
@@ -0,0 +1,86 @@
+package fetch_test
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrossCheck(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	otherOrg, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	env := map[string]string{"GO_TEST": "it"}
+	primary, _ := org.Response(env, true)
+	sameEnv, _ := org.Response(env, true)
+	staleEnv, _ := org.Response(map[string]string{"GO_TEST": "stale"}, true)
+	otherSigner, _ := otherOrg.Response(env, true)
+
+	failOpts := fetch.FetchOptions{TimeoutSeconds: 2.0, CrossCheck: true}
+	warnOpts := fetch.FetchOptions{TimeoutSeconds: 2.0, CrossCheck: true, CrossCheckPolicy: fetch.CrossCheckPolicyWarn}
+
+	unavailable := httpmock.NewStringResponder(http.StatusServiceUnavailable, "")
+
+	for _, test := range []struct {
+		desc      string
+		opts      fetch.FetchOptions
+		backup    httpmock.Responder
+		expectErr error
+	}{
+		{"matching backup", failOpts, httpmock.NewBytesResponder(http.StatusOK, sameEnv), nil},
+		{"backup with different env", failOpts, httpmock.NewBytesResponder(http.StatusOK, staleEnv), fetch.ErrCrossCheckMismatch},
+		{"backup with different signer", failOpts, httpmock.NewBytesResponder(http.StatusOK, otherSigner), fetch.ErrCrossCheckMismatch},
+		{"backup with different env, warn policy", warnOpts, httpmock.NewBytesResponder(http.StatusOK, staleEnv), nil},
+		{"backup unavailable", failOpts, unavailable, fetch.ErrCrossCheckUnavailable},
+		{"backup unavailable, warn policy", warnOpts, unavailable, nil},
+	} {
+		httpmock.Reset()
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, primary), test.backup, test.opts)
+
+		res, err := fetch.Fetch("validkey-anypassphrase", test.opts)
+		if test.expectErr == nil {
+			assert.Nil(t, err, test.desc)
+			assert.Equal(t, `{"GO_TEST":"it"}`, res, test.desc)
+		} else {
+			assert.True(t, errors.Is(err, test.expectErr), test.desc+": %v", err)
+			assert.Equal(t, "", res, test.desc)
+		}
+	}
+
+	// primary unavailable, so the response comes from a backup
+	httpmock.Reset()
+	registerResponders(unavailable, httpmock.NewBytesResponder(http.StatusOK, primary), failOpts)
+	_, err = fetch.Fetch("validkey-anypassphrase", failOpts)
+	assert.True(t, errors.Is(err, fetch.ErrCrossCheckUnavailable), "Should fail when the response didn't come from the primary: %v", err)
+	_, err = fetch.Fetch("validkey-anypassphrase", warnOpts)
+	assert.Nil(t, err, "Should only warn when the response didn't come from the primary.")
+
+	// custom hosts have no backups
+	httpmock.Reset()
+	httpmock.RegisterResponder("GET", fetch.UrlWithLoggingParams("https://example.com/v"+strconv.Itoa(fetch.ApiVersion)+"/validkey", failOpts), httpmock.NewBytesResponder(http.StatusOK, primary))
+	_, err = fetch.Fetch("validkey-anypassphrase-example.com", failOpts)
+	assert.True(t, errors.Is(err, fetch.ErrCrossCheckUnavailable), "Should fail for a custom host: %v", err)
+	_, err = fetch.Fetch("validkey-anypassphrase-example.com", warnOpts)
+	assert.Nil(t, err, "Should only warn for a custom host.")
+
+	_, err = fetch.Fetch("validkey-anypassphrase", fetch.FetchOptions{CrossCheck: true, CrossCheckPolicy: "bogus"})
+	assert.NotNil(t, err, "Should reject an unknown policy.")
+}
@@ -0,0 +1,90 @@
+package fetch_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/envkey/envkey-fetch/parser"
+	"github.com/envkey/envkey-fetch/trust"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptResponse(t *testing.T) {
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := org.Response(map[string]string{"GO_TEST": "it"}, true)
+
+	for _, key := range []string{"validkey-anypassphrase", "anypassphrase"} {
+		verifiedEnv, err := fetch.DecryptResponse(body, key, fetch.FetchOptions{})
+		if assert.Nil(t, err, "Should decrypt with %s.", key) {
+			assert.Equal(t, `{"GO_TEST":"it"}`, verifiedEnv.Json)
+			assert.Equal(t, org.SignerId, verifiedEnv.SignerId)
+		}
+	}
+
+	_, err = fetch.DecryptResponse(body, "", fetch.FetchOptions{})
+	assert.NotNil(t, err, "Should require a passphrase.")
+
+	_, err = fetch.DecryptResponse([]byte("{}"), "anypassphrase", fetch.FetchOptions{})
+	assert.True(t, errors.Is(err, fetch.ErrInvalidResponse), "Should reject an incomplete response.")
+
+	_, err = fetch.DecryptResponse([]byte("not json"), "anypassphrase", fetch.FetchOptions{})
+	assert.True(t, errors.Is(err, fetch.ErrInvalidJson), "Should reject invalid json.")
+
+	dir, err := ioutil.TempDir("", "envkey-trust-policy")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "trust_policy.json")
+	ioutil.WriteFile(path, []byte(`{"envkeys":{"validkey":{"deny_signer_ids":["`+org.SignerId+`"]}}}`), 0600)
+	opts := fetch.FetchOptions{TrustPolicyFile: path}
+
+	_, err = fetch.DecryptResponse(body, "validkey-anypassphrase", opts)
+	assert.True(t, errors.Is(err, trust.ErrPolicy), "Should apply the ENVKEY's trust policy.")
+
+	_, err = fetch.DecryptResponse(body, "anypassphrase", opts)
+	assert.Nil(t, err, "Should apply the default trust policy to a passphrase alone.")
+}
+
+func TestExplainResponse(t *testing.T) {
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := org.Response(map[string]string{"GO_TEST": "it"}, true)
+
+	explanation, err := fetch.ExplainResponse(body, "anypassphrase", fetch.FetchOptions{})
+	if assert.Nil(t, err) {
+		assert.True(t, explanation.Verified, "Should verify.")
+		assert.Len(t, explanation.Signers, 1)
+	}
+
+	// an env encrypted for another org's key fails at the last step
+	otherOrg, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	otherBody, _ := otherOrg.Response(map[string]string{"GO_TEST": "other"}, true)
+	var bad, other parser.EnvServiceResponse
+	json.Unmarshal(body, &bad)
+	json.Unmarshal(otherBody, &other)
+	bad.Env = other.Env
+	badBody, _ := json.Marshal(bad)
+
+	explanation, err = fetch.ExplainResponse(badBody, "anypassphrase", fetch.FetchOptions{})
+	if assert.Nil(t, err) {
+		assert.False(t, explanation.Verified, "Should not verify.")
+		assert.Equal(t, parser.StepDecryptAndVerify, explanation.Steps[len(explanation.Steps)-1].Name)
+	}
+}
@@ -0,0 +1,81 @@
+package fetch_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/envkey/envkey-fetch/parser"
+	"github.com/envkey/envkey-fetch/trust"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := org.Response(map[string]string{"GO_TEST": "it"}, true)
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, NoBackup: true}
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+	registerResponders(httpmock.NewBytesResponder(http.StatusOK, body), unavailable, opts)
+
+	explanation, result, err := fetch.Explain("validkey-anypassphrase", opts)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, fetch.SourcePrimary, result.Source)
+	assert.True(t, explanation.Verified, "Should verify.")
+	assert.Empty(t, result.Json, "Should not return the env.")
+
+	names := []string{}
+	for _, step := range explanation.Steps {
+		names = append(names, step.Name)
+		assert.Empty(t, step.Error)
+	}
+	assert.Equal(t, []string{parser.StepValidate, parser.StepParseKeys, parser.StepParseTrustChain, parser.StepVerifyTrusted, parser.StepDecryptAndVerify}, names)
+
+	if assert.Len(t, explanation.Signers, 1) {
+		signer := explanation.Signers[0]
+		assert.Equal(t, org.SignerId, signer.Id)
+		assert.Equal(t, trust.ListedInCreatorTrusted, signer.ListedIn)
+		assert.Equal(t, signer.Fingerprint, signer.TrustedFingerprint)
+		assert.True(t, signer.Trusted)
+	}
+
+	// an env encrypted for another org's key fails at the last step
+	otherOrg, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	otherBody, _ := otherOrg.Response(map[string]string{"GO_TEST": "other"}, true)
+	var bad, other parser.EnvServiceResponse
+	json.Unmarshal(body, &bad)
+	json.Unmarshal(otherBody, &other)
+	bad.Env = other.Env
+	badBody, _ := json.Marshal(bad)
+
+	httpmock.Reset()
+	registerResponders(httpmock.NewBytesResponder(http.StatusOK, badBody), unavailable, opts)
+
+	explanation, _, err = fetch.Explain("validkey-anypassphrase", opts)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.False(t, explanation.Verified, "Should not verify.")
+	if assert.Len(t, explanation.Steps, 5) {
+		assert.Empty(t, explanation.Steps[3].Error, "Signers should still be trusted.")
+		assert.NotEmpty(t, explanation.Steps[4].Error)
+	}
+
+}
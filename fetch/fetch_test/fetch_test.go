@@ -79,11 +79,11 @@ func TestFetch(t *testing.T) {
 	httpmock.ActivateNonDefault(fetch.Client)
 	defer httpmock.DeactivateAndReset()
 
-	opts := fetch.FetchOptions{true, "", "envkey-fetch", version.Version, false, 2.0, 1, 0.1}
+	opts := fetch.FetchOptions{ShouldCache: true, ClientName: "envkey-fetch", ClientVersion: version.Version, TimeoutSeconds: 2.0, Retries: 1, RetryBackoff: 0.1}
 
 	// Caching enabled
 	for _, test := range fetchTests {
-		var envkeyParam = strings.Split(test.envkey, "-")[0]
+		var envkeyParam, pw = strings.Split(test.envkey, "-")[0], []byte(strings.Split(test.envkey, "-")[1])
 
 		baseUrl := (test.protocol + "://" + test.host + "/v" + strconv.Itoa(fetch.ApiVersion) + "/" + envkeyParam)
 		url := fetch.UrlWithLoggingParams(baseUrl, opts)
@@ -108,17 +108,20 @@ func TestFetch(t *testing.T) {
 		var c *cache.Cache
 		if test.responseStatus == http.StatusOK && !test.expectErr && test.allowCaching {
 			c, _ = cache.NewCache("")
-			res, _ := c.Read(envkeyParam)
-			assert.Equal(test.response, string(res), "Should properly cache the response.")
+			entry, err := c.Read(envkeyParam, pw)
+			if assert.Nil(err, "Should properly cache the response.") {
+				assert.Equal(test.response, string(entry.Body), "Should properly cache the response.")
+				assert.Equal(url, entry.SourceUrl, "Should record the source url.")
+			}
 			c.Delete(envkeyParam)
 		} else {
 			// Ensure no caching
 			c, _ = cache.NewCache("")
-			_, err := c.Read(envkeyParam)
+			_, err := c.Read(envkeyParam, pw)
 			assert.NotNil(err, "Should not cache the response.")
 		}
 
-		res, err = fetch.Fetch(test.envkey, fetch.FetchOptions{false, "", "envkey-fetch", version.Version, false, 2.0, 1, 0.1})
+		res, err = fetch.Fetch(test.envkey, fetch.FetchOptions{ClientName: "envkey-fetch", ClientVersion: version.Version, TimeoutSeconds: 2.0, Retries: 1, RetryBackoff: 0.1})
 
 		// With caching disabled
 		if test.expectErr {
@@ -130,14 +133,14 @@ func TestFetch(t *testing.T) {
 
 		// Ensure no caching
 		c, _ = cache.NewCache("")
-		_, err = c.Read(envkeyParam)
+		_, err = c.Read(envkeyParam, pw)
 		assert.NotNil(err, "Should not cache the response.")
 
 		// Ensure retries
 		t.Run("failed requests should retry", func(t *testing.T) {
 			const retries = 3
 			const backoff = 0.1
-			opts := fetch.FetchOptions{true, "", "envkey-fetch", version.Version, false, 2.0, retries, backoff}
+			opts := fetch.FetchOptions{ShouldCache: true, ClientName: "envkey-fetch", ClientVersion: version.Version, TimeoutSeconds: 2.0, Retries: retries, RetryBackoff: backoff}
 			responder := httpmock.NewStringResponder(test.responseStatus, test.response)
 			callCount := 0
 			httpmock.RegisterResponder(
@@ -167,12 +170,12 @@ func TestLiveFetch(t *testing.T) {
 	assert := assert.New(t)
 
 	// Test valid
-	validRes, err := fetch.Fetch(VALID_LIVE_ENVKEY, fetch.FetchOptions{false, "", "envkey-fetch", version.Version, false, 2.0, 1, 0.1})
+	validRes, err := fetch.Fetch(VALID_LIVE_ENVKEY, fetch.FetchOptions{ClientName: "envkey-fetch", ClientVersion: version.Version, TimeoutSeconds: 2.0, Retries: 1, RetryBackoff: 0.1})
 	assert.Nil(err)
 	assert.Equal("{\"TEST\":\"it\",\"TEST_2\":\"works!\",\"TEST_INJECTION\":\"'$(uname)\",\"TEST_SINGLE_QUOTES\":\"this' is ok\",\"TEST_SPACES\":\"it does work!\",\"TEST_STRANGE_CHARS\":\"with quotes ` ' \\\\\\\" bäh\"}", validRes)
 
 	// Test invalid
-	invalidRes, err := fetch.Fetch(INVALID_LIVE_ENVKEY, fetch.FetchOptions{false, "", "envkey-fetch", version.Version, false, 2.0, 1, 0.1})
+	invalidRes, err := fetch.Fetch(INVALID_LIVE_ENVKEY, fetch.FetchOptions{ClientName: "envkey-fetch", ClientVersion: version.Version, TimeoutSeconds: 2.0, Retries: 1, RetryBackoff: 0.1})
 	assert.NotNil(err)
 	assert.Equal("ENVKEY invalid", string(err.Error()))
 	assert.Equal("", invalidRes)
@@ -185,8 +188,10 @@ func TestBackup(t *testing.T) {
 	defer httpmock.DeactivateAndReset()
 
 	// Test with backup
+	defaultHost := fetch.DefaultHost
+	defer func() { fetch.DefaultHost = defaultHost }()
 	fetch.DefaultHost = "localhost:61034"
-	opts := fetch.FetchOptions{false, "", "envkey-fetch", version.Version, false, 2.0, 1, 0.1}
+	opts := fetch.FetchOptions{ClientName: "envkey-fetch", ClientVersion: version.Version, TimeoutSeconds: 2.0, Retries: 1, RetryBackoff: 0.1}
 	url := fetch.UrlWithLoggingParams("https://"+fetch.BackupHost+"/v"+strconv.Itoa(fetch.ApiVersion)+"/validkey", opts)
 	restrictedUrl := fetch.UrlWithLoggingParams(fmt.Sprintf("%s?v=%s&id=%s", ("https://"+fetch.BackupHostRestricted), strconv.Itoa(fetch.ApiVersion), "validkey"), opts)
 
@@ -0,0 +1,88 @@
+package fetch_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/envkey/envkey-fetch/parser"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryAndRollback(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	first, _ := org.Response(map[string]string{"GO_TEST": "first", "REMOVED": "x"}, true)
+	second, _ := org.Response(map[string]string{"GO_TEST": "second", "ADDED": "y"}, true)
+
+	// an env encrypted for another org's key can't be decrypted
+	otherOrg, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	otherResponse, _ := otherOrg.Response(map[string]string{"GO_TEST": "bad"}, true)
+	var bad, other parser.EnvServiceResponse
+	json.Unmarshal(second, &bad)
+	json.Unmarshal(otherResponse, &other)
+	bad.Env = other.Env
+	badPush, _ := json.Marshal(bad)
+
+	store := cache.NewMemoryStore()
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, ShouldCache: true, CacheStore: store, NoBackup: true}
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+
+	for _, body := range [][]byte{first, second} {
+		httpmock.Reset()
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, body), unavailable, opts)
+		_, err = fetch.Fetch("validkey-anypassphrase", opts)
+		assert.Nil(t, err)
+		// distinct fetch times
+		time.Sleep(time.Millisecond)
+	}
+
+	// a response that fails verification is neither cached nor clears the cache
+	httpmock.Reset()
+	registerResponders(httpmock.NewBytesResponder(http.StatusOK, badPush), unavailable, opts)
+	_, err = fetch.Fetch("validkey-anypassphrase", opts)
+	assert.NotNil(t, err)
+
+	generations, err := fetch.History("validkey-anypassphrase", opts)
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(generations)) {
+		assert.Nil(t, generations[0].Err)
+		assert.Equal(t, "signer-id", generations[0].SignerId)
+		assert.Equal(t, []string{"ADDED", "GO_TEST"}, generations[0].Keys)
+		assert.Equal(t, []string{"ADDED"}, generations[0].Added)
+		assert.Equal(t, []string{"REMOVED"}, generations[0].Removed)
+		assert.Equal(t, []string{"GO_TEST"}, generations[0].Changed)
+		assert.Nil(t, generations[1].Added, "Should not diff the oldest generation.")
+	}
+
+	httpmock.Reset()
+	rollbackOpts := opts
+	rollbackOpts.Rollback = 1
+	result, err := fetch.FetchWithResult("validkey-anypassphrase", rollbackOpts)
+	if assert.Nil(t, err) {
+		assert.Equal(t, `{"GO_TEST":"first","REMOVED":"x"}`, result.Json)
+		assert.Equal(t, fetch.SourceCache, result.Source)
+		assert.Equal(t, 1, result.Generation)
+	}
+	assert.Equal(t, 0, httpmock.GetTotalCallCount(), "Should not fetch when rolling back.")
+
+	rollbackOpts.Rollback = 2
+	_, err = fetch.Fetch("validkey-anypassphrase", rollbackOpts)
+	assert.NotNil(t, err, "Should fail for a generation that isn't cached.")
+}
@@ -0,0 +1,152 @@
+package fetch_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKnownSigners(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := org.Response(map[string]string{"GO_TEST": "it"}, true)
+
+	// same signer id, but a different root key
+	otherOrg, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	otherBody, _ := otherOrg.Response(map[string]string{"GO_TEST": "other"}, true)
+
+	dir, err := ioutil.TempDir("", "envkey-known-signers")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "known_signers")
+
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+	serve := func(body []byte, opts fetch.FetchOptions) {
+		httpmock.Reset()
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, body), unavailable, opts)
+	}
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, NoBackup: true, KnownSigners: fetch.KnownSignersStrict, KnownSignersFile: path}
+
+	// recorded on first use
+	serve(body, opts)
+	_, err = fetch.Fetch("validkey-anypassphrase", opts)
+	assert.Nil(t, err)
+	recorded, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(recorded), " root "+org.SignerId+" ")
+
+	serve(otherBody, opts)
+	_, err = fetch.Fetch("validkey-anypassphrase", opts)
+	assert.True(t, errors.Is(err, fetch.ErrSignersChanged), "Should fail in strict mode.")
+
+	warnOpts := opts
+	warnOpts.KnownSigners = fetch.KnownSignersWarn
+	serve(otherBody, warnOpts)
+	_, err = fetch.Fetch("validkey-anypassphrase", warnOpts)
+	assert.Nil(t, err, "Should only warn.")
+
+	// a warning doesn't approve the change
+	serve(otherBody, opts)
+	_, err = fetch.Fetch("validkey-anypassphrase", opts)
+	assert.True(t, errors.Is(err, fetch.ErrSignersChanged), "Should still fail in strict mode.")
+
+	serve(otherBody, opts)
+	changes, err := fetch.AcceptSigners("validkey-anypassphrase", opts)
+	assert.Nil(t, err)
+	assert.Len(t, changes, 2, "Both the root and signer keys changed.")
+
+	serve(otherBody, opts)
+	_, err = fetch.Fetch("validkey-anypassphrase", opts)
+	assert.Nil(t, err, "Should pass once accepted.")
+
+	serve(body, opts)
+	_, err = fetch.Fetch("validkey-anypassphrase", opts)
+	assert.True(t, errors.Is(err, fetch.ErrSignersChanged), "The old root is no longer known.")
+
+	// a cached response isn't accepted, even with the cache enabled
+	cacheOpts := opts
+	cacheOpts.ShouldCache = true
+	cacheOpts.CacheDir = testCacheDir
+	defer os.RemoveAll(testCacheDir)
+	serve(body, cacheOpts)
+	_, err = fetch.Fetch("validkey-anypassphrase", fetch.FetchOptions{TimeoutSeconds: 2.0, NoBackup: true, ShouldCache: true, CacheDir: testCacheDir})
+	assert.Nil(t, err)
+	httpmock.Reset()
+	registerResponders(unavailable, unavailable, cacheOpts)
+	_, err = fetch.AcceptSigners("validkey-anypassphrase", cacheOpts)
+	assert.NotNil(t, err, "Should require a fresh response.")
+
+	offlineOpts := cacheOpts
+	offlineOpts.Offline = true
+	_, err = fetch.AcceptSigners("validkey-anypassphrase", offlineOpts)
+	assert.NotNil(t, err, "Should refuse to accept offline.")
+}
+
+func TestKnownSignersLock(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := org.Response(map[string]string{"GO_TEST": "it"}, true)
+
+	dir, err := ioutil.TempDir("", "envkey-known-signers")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, NoBackup: true, KnownSigners: fetch.KnownSignersStrict, KnownSignersFile: filepath.Join(dir, "known_signers")}
+	registerResponders(httpmock.NewBytesResponder(http.StatusOK, body), contentTypeResponder(http.StatusServiceUnavailable, "", ""), opts)
+
+	// another process changing the file
+	unlock, err := cache.LockPath(filepath.Join(dir, ".known_signers.lock"))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fetch.Fetch("validkey-anypassphrase", opts)
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		unlock()
+		t.Fatal("Should wait for the known signers lock.")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	unlock()
+	assert.Nil(t, <-done)
+	recorded, err := ioutil.ReadFile(opts.KnownSignersFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(recorded), " root "+org.SignerId+" ", "Should record once the lock is released.")
+}
@@ -0,0 +1,110 @@
+package fetch_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/fetch"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const wrongPin = "sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+func initPinnedClient(server *httptest.Server, options fetch.FetchOptions) error {
+	options.CAStrategy = fetch.CAStrategyFile
+	options.CAFile = writeCAFile(server)
+	defer os.Remove(options.CAFile)
+
+	err := fetch.InitHttpClientWithOptions(options)
+	if err != nil {
+		return err
+	}
+	routeToServer(server)
+	return nil
+}
+
+func TestPins(t *testing.T) {
+	assert := assert.New(t)
+	server := newTLSEnvServer()
+	defer server.Close()
+	defer func() { fetch.Client = nil }()
+
+	envkey := validEnvkeySimple + "-example.com"
+	pin := "example.com=" + fetch.SpkiPin(server.Certificate())
+
+	// matching pin
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, Pins: []string{pin}}
+	assert.Nil(initPinnedClient(server, opts))
+	res, err := fetch.Fetch(envkey, opts)
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should load with a matching pin.")
+
+	// mismatched pin
+	opts = fetch.FetchOptions{TimeoutSeconds: 2.0, Pins: []string{"example.com=" + wrongPin}}
+	assert.Nil(initPinnedClient(server, opts))
+	res, err = fetch.Fetch(envkey, opts)
+	assert.NotNil(err, "Should fail with a mismatched pin.")
+	assert.Equal("", res)
+
+	// mismatched pin, report only
+	opts = fetch.FetchOptions{TimeoutSeconds: 2.0, Pins: []string{"example.com=" + wrongPin}, PinReportOnly: true}
+	assert.Nil(initPinnedClient(server, opts))
+	res, err = fetch.Fetch(envkey, opts)
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should only report a mismatched pin in report only mode.")
+
+	// pins for other hosts are ignored
+	opts = fetch.FetchOptions{TimeoutSeconds: 2.0, Pins: []string{fetch.DefaultHost + "=" + wrongPin}}
+	assert.Nil(initPinnedClient(server, opts))
+	res, err = fetch.Fetch(envkey, opts)
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should ignore pins for other hosts.")
+}
+
+func TestPinsPerCall(t *testing.T) {
+	assert := assert.New(t)
+	server := newTLSEnvServer()
+	defer server.Close()
+
+	caFile := writeCAFile(server)
+	defer os.Remove(caFile)
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	envkey := validEnvkeySimple + "-example.com:" + port
+	options := func(pin string) fetch.FetchOptions {
+		return fetch.FetchOptions{
+			TimeoutSeconds: 2.0,
+			CAStrategy:     fetch.CAStrategyFile,
+			CAFile:         caFile,
+			Resolve:        []string{"example.com:" + port + ":127.0.0.1"},
+			Pins:           []string{"example.com=" + pin},
+		}
+	}
+
+	// without fetch.Client set, each call builds a client from its own options
+	res, err := fetch.Fetch(envkey, options(fetch.SpkiPin(server.Certificate())))
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should load with a matching pin.")
+
+	res, err = fetch.Fetch(envkey, options(wrongPin))
+	assert.NotNil(err, "Should enforce a pin passed after an earlier call without it.")
+	assert.Equal("", res)
+}
+
+func TestInvalidPins(t *testing.T) {
+	defer func() { fetch.Client = nil }()
+
+	for _, pin := range []string{
+		"127.0.0.1",
+		"=" + wrongPin,
+		"127.0.0.1=sha1/AAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"127.0.0.1=sha256/not-base64",
+		"127.0.0.1=sha256/AAAA",
+	} {
+		err := fetch.InitHttpClientWithOptions(fetch.FetchOptions{TimeoutSeconds: 2.0, Pins: []string{pin}})
+		assert.NotNil(t, err, "Should reject invalid pin: "+pin)
+	}
+}
@@ -0,0 +1,153 @@
+package fetch_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// matches any error in table tests
+var errAny = errors.New("any error")
+
+func TestSourcePolicy(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	c, _ := cache.NewCache(testCacheDir)
+	defer c.Delete("validkey")
+	pw := []byte(strings.Split(validEnvkeySimple, "-")[1])
+
+	valid := contentTypeResponder(http.StatusOK, "application/json", responseSimple)
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+	forbidden := contentTypeResponder(http.StatusForbidden, "", "")
+
+	opts := func(o fetch.FetchOptions) fetch.FetchOptions {
+		o.TimeoutSeconds = 2.0
+		o.ShouldCache = true
+		o.CacheDir = testCacheDir
+		return o
+	}
+
+	for _, test := range []struct {
+		desc         string
+		opts         fetch.FetchOptions
+		cacheAge     time.Duration
+		primary      httpmock.Responder
+		backup       httpmock.Responder
+		expectSource string
+		expectErr    error
+		expectCalls  int
+	}{
+		{"default", opts(fetch.FetchOptions{}), 0, unavailable, valid, fetch.SourceBackup, nil, 3},
+		{"offline", opts(fetch.FetchOptions{Offline: true}), 0, valid, valid, fetch.SourceCache, nil, 0},
+		{"no backup", opts(fetch.FetchOptions{NoBackup: true}), 0, unavailable, valid, fetch.SourceCache, nil, 1},
+		{"require fresh", opts(fetch.FetchOptions{RequireFresh: true}), 0, unavailable, unavailable, "", errAny, 3},
+		{"require fresh, primary available", opts(fetch.FetchOptions{RequireFresh: true}), 0, valid, unavailable, fetch.SourcePrimary, nil, 1},
+		{"cache only on outage, outage", opts(fetch.FetchOptions{CacheOnlyOnOutage: true}), 0, unavailable, unavailable, fetch.SourceCache, nil, 3},
+		{"cache only on outage, refused", opts(fetch.FetchOptions{CacheOnlyOnOutage: true}), 0, forbidden, unavailable, "", fetch.ErrCacheNotOutage, 1},
+		{"max stale", opts(fetch.FetchOptions{MaxStale: time.Hour}), 2 * time.Hour, unavailable, unavailable, "", fetch.ErrStaleCache, 3},
+		{"max stale, fresh enough", opts(fetch.FetchOptions{MaxStale: 3 * time.Hour}), 2 * time.Hour, unavailable, unavailable, fetch.SourceCache, nil, 3},
+		{"offline, max stale", opts(fetch.FetchOptions{Offline: true, MaxStale: time.Hour}), 2 * time.Hour, valid, valid, "", fetch.ErrStaleCache, 0},
+	} {
+		c.Write("validkey", pw, &cache.Entry{FetchedAt: time.Now().Add(-test.cacheAge), SourceUrl: "https://env.envkey.com/v1/validkey", Body: []byte(responseSimple)})
+
+		httpmock.Reset()
+		registerResponders(test.primary, test.backup, test.opts)
+
+		result, err := fetch.FetchWithResult(validEnvkeySimple, test.opts)
+		if test.expectErr == nil {
+			if assert.Nil(t, err, test.desc) {
+				assert.Equal(t, validResult, result.Json, test.desc)
+				assert.Equal(t, test.expectSource, result.Source, test.desc)
+			}
+		} else if test.expectErr == errAny {
+			assert.NotNil(t, err, test.desc)
+		} else {
+			assert.True(t, errors.Is(err, test.expectErr), test.desc+": %v", err)
+		}
+		assert.Equal(t, test.expectCalls, httpmock.GetTotalCallCount(), test.desc+": requests made")
+	}
+}
+
+func TestFetchResult(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, NoBackup: true}
+	registerResponders(contentTypeResponder(http.StatusOK, "application/json", responseSimple), contentTypeResponder(http.StatusServiceUnavailable, "", ""), opts)
+
+	before := time.Now()
+	result, err := fetch.FetchWithResult(validEnvkeySimple, opts)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, validResult, result.Json)
+	assert.Equal(t, fetch.SourcePrimary, result.Source)
+	assert.Equal(t, fetch.UrlWithLoggingParams("https://"+fetch.DefaultHost+"/v1/validkey", opts), result.SourceUrl)
+	assert.False(t, result.FetchedAt.Before(before))
+	assert.Equal(t, []string{fetch.SourcePrimary}, result.AllowedSources)
+}
+
+func TestInvalidSourcePolicy(t *testing.T) {
+	for _, opts := range []fetch.FetchOptions{
+		{Offline: true, RequireFresh: true},
+		{Offline: true, CrossCheck: true},
+		{Offline: true, PreferCache: true},
+		{Offline: true, CacheOnlyOnOutage: true},
+		{NoBackup: true, CrossCheck: true},
+		{RequireFresh: true, PreferCache: true},
+		{MaxStale: -time.Hour},
+		{Rollback: 1, RequireFresh: true},
+		{Rollback: 1, PreferCache: true},
+		{Rollback: 1, CrossCheck: true},
+		{Rollback: 1, CacheOnlyOnOutage: true},
+		{Rollback: -1},
+		{Offline: true, Coalesce: true},
+		{Rollback: 1, Coalesce: true},
+		{RequireFresh: true, Coalesce: true},
+	} {
+		_, err := fetch.Fetch(validEnvkeySimple, opts)
+		assert.NotNil(t, err, "%+v should be rejected", opts)
+	}
+}
+
+func TestCacheStore(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	store := cache.NewMemoryStore()
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, ShouldCache: true, CacheStore: store}
+
+	registerResponders(contentTypeResponder(http.StatusOK, "application/json", responseSimple), contentTypeResponder(http.StatusServiceUnavailable, "", ""), opts)
+	_, err := fetch.Fetch(validEnvkeySimple, opts)
+	assert.Nil(t, err)
+
+	infos, err := store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos), "Should write to the given store.")
+
+	httpmock.Reset()
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+	registerResponders(unavailable, unavailable, opts)
+
+	result, err := fetch.FetchWithResult(validEnvkeySimple, opts)
+	if assert.Nil(t, err) {
+		assert.Equal(t, fetch.SourceCache, result.Source)
+		assert.Equal(t, validResult, result.Json)
+	}
+}
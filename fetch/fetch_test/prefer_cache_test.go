@@ -0,0 +1,155 @@
+package fetch_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type refreshed struct {
+	res string
+	err error
+}
+
+// blockingResponder waits for release before responding with body.
+func blockingResponder(release chan struct{}, body []byte) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		<-release
+		return httpmock.NewBytesResponse(http.StatusOK, body), nil
+	}
+}
+
+func TestPreferCache(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	cached, _ := org.Response(map[string]string{"GO_TEST": "cached"}, true)
+	fresh, _ := org.Response(map[string]string{"GO_TEST": "fresh"}, true)
+
+	c, _ := cache.NewCache(testCacheDir)
+	defer c.Delete("validkey")
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, CacheDir: testCacheDir, PreferCache: true, CacheMaxAge: time.Hour}
+	unavailable := httpmock.NewStringResponder(http.StatusServiceUnavailable, "")
+
+	t.Run("fresh cache entry is returned before the refresh", func(t *testing.T) {
+		c.Write("validkey", []byte("anypassphrase"), &cache.Entry{FetchedAt: time.Now(), Body: cached})
+
+		release := make(chan struct{})
+		httpmock.Reset()
+		registerResponders(blockingResponder(release, fresh), unavailable, opts)
+
+		refreshChan := make(chan refreshed, 1)
+		res, err := fetch.FetchWithRefresh("validkey-anypassphrase", opts, func(res string, err error) {
+			refreshChan <- refreshed{res, err}
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, `{"GO_TEST":"cached"}`, res, "Should return the cached env without waiting for the server.")
+
+		close(release)
+		r := <-refreshChan
+		assert.Nil(t, r.err)
+		assert.Equal(t, `{"GO_TEST":"fresh"}`, r.res, "Should pass the fresh env to the callback.")
+
+		entry, err := c.Read("validkey", []byte("anypassphrase"))
+		if assert.Nil(t, err) {
+			assert.Equal(t, fresh, entry.Body, "Should refresh the cache.")
+		}
+	})
+
+	t.Run("stale cache entry is ignored", func(t *testing.T) {
+		c.Write("validkey", []byte("anypassphrase"), &cache.Entry{FetchedAt: time.Now().Add(-2 * time.Hour), Body: cached})
+
+		httpmock.Reset()
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, fresh), unavailable, opts)
+
+		var r refreshed
+		res, err := fetch.FetchWithRefresh("validkey-anypassphrase", opts, func(res string, err error) {
+			r = refreshed{res, err}
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, `{"GO_TEST":"fresh"}`, res, "Should fetch when the cache entry is older than the max age.")
+		assert.Equal(t, refreshed{res, nil}, r, "Should call the callback before returning.")
+	})
+
+	t.Run("failed refresh doesn't fall back to the cache", func(t *testing.T) {
+		c.Write("validkey", []byte("anypassphrase"), &cache.Entry{FetchedAt: time.Now(), Body: cached})
+
+		httpmock.Reset()
+		registerResponders(unavailable, unavailable, opts)
+
+		refreshChan := make(chan refreshed, 1)
+		res, err := fetch.FetchWithRefresh("validkey-anypassphrase", opts, func(res string, err error) {
+			refreshChan <- refreshed{res, err}
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, `{"GO_TEST":"cached"}`, res)
+
+		r := <-refreshChan
+		assert.NotNil(t, r.err, "Should report the failed refresh.")
+		assert.Equal(t, "", r.res)
+	})
+
+	t.Run("refresh is bounded", func(t *testing.T) {
+		c.Write("validkey", []byte("anypassphrase"), &cache.Entry{FetchedAt: time.Now(), Body: cached})
+
+		release := make(chan struct{})
+		httpmock.Reset()
+		registerResponders(blockingResponder(release, fresh), unavailable, opts)
+
+		timeoutOpts := opts
+		timeoutOpts.RefreshTimeout = 100 * time.Millisecond
+
+		refreshChan := make(chan refreshed, 1)
+		res, err := fetch.FetchWithRefresh("validkey-anypassphrase", timeoutOpts, func(res string, err error) {
+			refreshChan <- refreshed{res, err}
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, `{"GO_TEST":"cached"}`, res)
+
+		r := <-refreshChan
+		assert.True(t, errors.Is(r.err, fetch.ErrRefreshTimeout), "Should give up on the refresh after the refresh timeout.")
+
+		close(release)
+		assert.Eventually(t, func() bool {
+			entry, err := c.Read("validkey", []byte("anypassphrase"))
+			return err == nil && string(entry.Body) == string(fresh)
+		}, 2*time.Second, 10*time.Millisecond, "Should still update the cache once the request finishes.")
+	})
+
+	t.Run("passphrase locked into memory", func(t *testing.T) {
+		c.Write("validkey", []byte("anypassphrase"), &cache.Entry{FetchedAt: time.Now(), Body: cached})
+
+		httpmock.Reset()
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, fresh), unavailable, opts)
+
+		lockOpts := opts
+		lockOpts.LockMemory = true
+
+		refreshChan := make(chan refreshed, 1)
+		res, err := fetch.FetchWithRefresh("validkey-anypassphrase", lockOpts, func(res string, err error) {
+			refreshChan <- refreshed{res, err}
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, `{"GO_TEST":"cached"}`, res)
+
+		r := <-refreshChan
+		assert.Nil(t, r.err)
+		assert.Equal(t, `{"GO_TEST":"fresh"}`, r.res, "Should refresh the same way, whether or not memory could be locked.")
+	})
+}
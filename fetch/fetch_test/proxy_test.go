@@ -0,0 +1,123 @@
+package fetch_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTempFile(contents string) string {
+	f, _ := ioutil.TempFile("", "envkey-fetch")
+	defer f.Close()
+	f.WriteString(contents)
+	return f.Name()
+}
+
+func TestProxy(t *testing.T) {
+	assert := assert.New(t)
+	defer func() { fetch.Client = nil }()
+
+	var proxied *http.Request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r
+		user, pass, ok := (&http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}).BasicAuth()
+		if !ok || user != "proxyuser" || pass != "proxy:pass" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Write([]byte(responseSimple))
+	}))
+	defer proxy.Close()
+
+	credentialsFile := writeTempFile("proxyuser:proxy:pass\n")
+	defer os.Remove(credentialsFile)
+
+	opts := fetch.FetchOptions{
+		TimeoutSeconds:       2.0,
+		Proxy:                proxy.URL,
+		ProxyCredentialsFile: credentialsFile,
+		Headers:              []string{"X-Gateway-Token: secret", "x-correlation-id: abc"},
+		MetadataHeaders:      true,
+		ClientName:           "test-client",
+	}
+	assert.Nil(fetch.InitHttpClientWithOptions(opts))
+
+	res, err := fetch.Fetch(validEnvkeySimple+"-"+customLocalHost, opts)
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should load through the proxy.")
+
+	if assert.NotNil(proxied) {
+		assert.Equal("http://"+customLocalHost+"/v1/validkey", proxied.RequestURI, "Should send metadata as headers instead of query params.")
+		assert.Equal("secret", proxied.Header.Get("X-Gateway-Token"))
+		assert.Equal("abc", proxied.Header.Get("X-Correlation-Id"))
+		assert.Equal("test-client", proxied.Header.Get("X-Envkey-Client-Name"))
+	}
+
+	// without credentials
+	opts.ProxyCredentialsFile = ""
+	assert.Nil(fetch.InitHttpClientWithOptions(opts))
+	_, err = fetch.Fetch(validEnvkeySimple+"-"+customLocalHost, opts)
+	assert.NotNil(err, "Should fail without proxy credentials.")
+}
+
+func TestInvalidProxyOptions(t *testing.T) {
+	defer func() { fetch.Client = nil }()
+
+	badCredentialsFile := writeTempFile("no-password")
+	defer os.Remove(badCredentialsFile)
+
+	for desc, opts := range map[string]fetch.FetchOptions{
+		"unsupported scheme":         {Proxy: "ftp://proxy.example.com:21"},
+		"missing host":               {Proxy: "http://"},
+		"credentials without proxy":  {ProxyCredentialsFile: badCredentialsFile},
+		"missing credentials file":   {Proxy: "socks5://proxy.example.com:1080", ProxyCredentialsFile: "/nonexistent/creds"},
+		"malformed credentials file": {Proxy: "socks5://proxy.example.com:1080", ProxyCredentialsFile: badCredentialsFile},
+	} {
+		assert.NotNil(t, fetch.InitHttpClientWithOptions(opts), desc)
+	}
+
+	assert.Nil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{Proxy: "socks5://proxy.example.com:1080"}), "Should accept socks5 proxies.")
+
+	for _, header := range []string{"no-colon", ": no name", "Bad Name: value", "X-Split: a\r\nX-Injected: b"} {
+		_, err := fetch.Fetch(validEnvkeySimple, fetch.FetchOptions{Headers: []string{header}})
+		assert.NotNil(t, err, "Should reject invalid header: "+header)
+	}
+}
+
+func TestBackupHeaders(t *testing.T) {
+	assert := assert.New(t)
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	defaultHost := fetch.DefaultHost
+	defer func() { fetch.DefaultHost = defaultHost }()
+	fetch.DefaultHost = "localhost:61034"
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, Headers: []string{"X-Gateway-Token: secret"}, MetadataHeaders: true}
+	url := "https://" + fetch.BackupHost + "/v" + strconv.Itoa(fetch.ApiVersion) + "/validkey"
+	restrictedUrl := fmt.Sprintf("%s?v=%s&id=%s", ("https://" + fetch.BackupHostRestricted), strconv.Itoa(fetch.ApiVersion), "validkey")
+
+	responder := func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("X-Gateway-Token") != "secret" || req.Header.Get("X-Envkey-Client-Name") != "envkey-fetch" {
+			return httpmock.NewStringResponse(http.StatusForbidden, ""), nil
+		}
+		return httpmock.NewStringResponse(http.StatusOK, responseSimple), nil
+	}
+	httpmock.RegisterResponder("GET", url, responder)
+	httpmock.RegisterResponder("GET", restrictedUrl, responder)
+
+	res, err := fetch.Fetch(validEnvkeySimple, opts)
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should send headers to backups.")
+}
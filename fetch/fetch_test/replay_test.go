@@ -0,0 +1,64 @@
+package fetch_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayPolicy(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	older, _ := org.Response(map[string]string{"GO_TEST": "older"}, true)
+	newer, _ := org.Response(map[string]string{"GO_TEST": "newer"}, true)
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+
+	fetchBody := func(body []byte, opts fetch.FetchOptions) (string, error) {
+		httpmock.Reset()
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, body), unavailable, opts)
+		return fetch.Fetch("validkey-anypassphrase", opts)
+	}
+
+	for _, test := range []struct {
+		policy    string
+		expectErr error
+	}{
+		{fetch.ReplayPolicyOff, nil},
+		{fetch.ReplayPolicyWarn, nil},
+		{fetch.ReplayPolicyRefuse, cache.ErrReplay},
+	} {
+		// caching isn't needed to track versions
+		opts := fetch.FetchOptions{TimeoutSeconds: 2.0, CacheStore: cache.NewMemoryStore(), NoBackup: true, ReplayPolicy: test.policy}
+
+		for _, body := range [][]byte{older, newer, newer} {
+			_, err = fetchBody(body, opts)
+			assert.Nil(t, err, test.policy)
+		}
+
+		res, err := fetchBody(older, opts)
+		if test.expectErr == nil {
+			assert.Nil(t, err, test.policy)
+			assert.Equal(t, `{"GO_TEST":"older"}`, res, test.policy)
+		} else {
+			assert.True(t, errors.Is(err, test.expectErr), test.policy+": %v", err)
+		}
+	}
+
+	_, err = fetch.Fetch("validkey-anypassphrase", fetch.FetchOptions{ReplayPolicy: "sometimes"})
+	assert.NotNil(t, err, "Should reject an unknown policy.")
+}
@@ -0,0 +1,136 @@
+package fetch_test
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/fetch"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startDNSServer answers every A query with 127.0.0.1 and every other query with no records.
+func startDNSServer() (*net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+
+			// find the end of the question (name, type, class)
+			i := 12
+			for i < n && buf[i] != 0 {
+				i += int(buf[i]) + 1
+			}
+			if i+5 > n {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(buf[i+1:])
+			question := buf[12 : i+5]
+
+			resp := make([]byte, 12, 64)
+			copy(resp, buf[:2])                          // id
+			binary.BigEndian.PutUint16(resp[2:], 0x8180) // response, recursion available
+			binary.BigEndian.PutUint16(resp[4:], 1)      // questions
+			resp = append(resp, question...)
+
+			if qtype == 1 {
+				binary.BigEndian.PutUint16(resp[6:], 1) // answers
+				resp = append(resp,
+					0xc0, 0x0c, // name pointer to question
+					0, 1, 0, 1, // type A, class IN
+					0, 0, 0, 60, // ttl
+					0, 4, 127, 0, 0, 1,
+				)
+			}
+
+			conn.WriteToUDP(resp, addr)
+		}
+	}()
+
+	return conn, nil
+}
+
+func TestResolveOverride(t *testing.T) {
+	assert := assert.New(t)
+	server := newTLSEnvServer()
+	defer server.Close()
+	defer func() { fetch.Client = nil }()
+
+	caFile := writeCAFile(server)
+	defer os.Remove(caFile)
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	opts := fetch.FetchOptions{
+		TimeoutSeconds: 2.0,
+		CAStrategy:     fetch.CAStrategyFile,
+		CAFile:         caFile,
+		Resolve:        []string{"example.com:" + port + ":127.0.0.1"},
+	}
+	assert.Nil(fetch.InitHttpClientWithOptions(opts))
+
+	res, err := fetch.Fetch(validEnvkeySimple+"-example.com:"+port, opts)
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should connect to the override address.")
+}
+
+func TestDNSServer(t *testing.T) {
+	assert := assert.New(t)
+	server := newTLSEnvServer()
+	defer server.Close()
+	defer func() { fetch.Client = nil }()
+
+	dns, err := startDNSServer()
+	if !assert.Nil(err) {
+		return
+	}
+	defer dns.Close()
+
+	caFile := writeCAFile(server)
+	defer os.Remove(caFile)
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	opts := fetch.FetchOptions{
+		TimeoutSeconds: 2.0,
+		CAStrategy:     fetch.CAStrategyFile,
+		CAFile:         caFile,
+		DNSServer:      dns.LocalAddr().String(),
+	}
+	assert.Nil(fetch.InitHttpClientWithOptions(opts))
+
+	res, err := fetch.Fetch(validEnvkeySimple+"-example.com:"+port, opts)
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should resolve with the supplied DNS server.")
+}
+
+func TestInvalidResolveOptions(t *testing.T) {
+	defer func() { fetch.Client = nil }()
+
+	for _, resolve := range []string{
+		"example.com",
+		"example.com:443",
+		":443:127.0.0.1",
+		"example.com:https:127.0.0.1",
+		"example.com:443:not-an-ip",
+	} {
+		assert.NotNil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{Resolve: []string{resolve}}), "Should reject invalid override: "+resolve)
+	}
+
+	assert.Nil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{Resolve: []string{"example.com:443:[::1]"}}), "Should accept bracketed IPv6 addresses.")
+	assert.Nil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{DNSServer: "10.0.0.53"}), "Should default the DNS port.")
+	assert.NotNil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{DNSServer: "dns.example.com"}), "Should require a DNS server IP.")
+}
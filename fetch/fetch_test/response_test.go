@@ -0,0 +1,185 @@
+package fetch_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func contentTypeResponder(status int, contentType, body string) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(status, body)
+		if contentType != "" {
+			resp.Header.Set("Content-Type", contentType)
+		}
+		return resp, nil
+	}
+}
+
+func registerResponders(primary, backup httpmock.Responder, opts fetch.FetchOptions) {
+	apiVersion := strconv.Itoa(fetch.ApiVersion)
+	httpmock.RegisterResponder("GET", fetch.UrlWithLoggingParams("https://"+fetch.DefaultHost+"/v"+apiVersion+"/validkey", opts), primary)
+	httpmock.RegisterResponder("GET", fetch.UrlWithLoggingParams("https://"+fetch.BackupHost+"/v"+apiVersion+"/validkey", opts), backup)
+	httpmock.RegisterResponder("GET", fetch.UrlWithLoggingParams(fmt.Sprintf("%s?v=%s&id=%s", "https://"+fetch.BackupHostRestricted, apiVersion, "validkey"), opts), backup)
+}
+
+const testCacheDir = "~/.envkey/cache/fetch-test"
+
+var responseUnknownField = strings.Replace(responseSimple, `{"env":`, `{"unknown_field":"x","env":`, 1)
+
+var responseDuplicateField = strings.Replace(responseSimple, `{"env":`, `{"signed_by_id":"x","env":`, 1)
+
+func TestResponseHardening(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0}
+	strictOpts := fetch.FetchOptions{TimeoutSeconds: 2.0, StrictResponse: true}
+	smallOpts := fetch.FetchOptions{TimeoutSeconds: 2.0, MaxBodyBytes: 100}
+
+	valid := contentTypeResponder(http.StatusOK, "application/json", responseSimple)
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+
+	for _, test := range []struct {
+		desc      string
+		opts      fetch.FetchOptions
+		primary   httpmock.Responder
+		backup    httpmock.Responder
+		expectErr error
+	}{
+		{"valid json", strictOpts, valid, unavailable, nil},
+		{"no content type", opts, contentTypeResponder(http.StatusOK, "", responseSimple), unavailable, nil},
+		{"octet stream", opts, contentTypeResponder(http.StatusOK, "binary/octet-stream", responseSimple), unavailable, nil},
+		{"unknown field", opts, contentTypeResponder(http.StatusOK, "application/json", responseUnknownField), unavailable, nil},
+
+		{"html falls back to backup", opts, contentTypeResponder(http.StatusOK, "text/html", "<html></html>"), valid, nil},
+		{"junk json falls back to backup", opts, contentTypeResponder(http.StatusOK, "application/json", "not json"), valid, nil},
+		{"empty object falls back to backup", opts, contentTypeResponder(http.StatusOK, "application/json", "{}"), valid, nil},
+
+		{"html", opts, contentTypeResponder(http.StatusOK, "text/html", "<html></html>"), unavailable, fetch.ErrContentType},
+		{"too large", smallOpts, valid, unavailable, fetch.ErrBodyTooLarge},
+		{"junk json", opts, contentTypeResponder(http.StatusOK, "application/json", "not json"), unavailable, fetch.ErrInvalidJson},
+		{"missing fields", opts, contentTypeResponder(http.StatusOK, "application/json", `{"env":"x"}`), unavailable, fetch.ErrInvalidResponse},
+		{"strict, no content type", strictOpts, contentTypeResponder(http.StatusOK, "", responseSimple), unavailable, fetch.ErrContentType},
+		{"strict, text/plain", strictOpts, contentTypeResponder(http.StatusOK, "text/plain", responseSimple), unavailable, fetch.ErrContentType},
+		{"strict, unknown field", strictOpts, contentTypeResponder(http.StatusOK, "application/json", responseUnknownField), unavailable, fetch.ErrInvalidJson},
+		{"strict, duplicate field", strictOpts, contentTypeResponder(http.StatusOK, "application/json", responseDuplicateField), unavailable, fetch.ErrInvalidJson},
+		{"strict, trailing data", strictOpts, contentTypeResponder(http.StatusOK, "application/json", responseSimple+"{}"), unavailable, fetch.ErrInvalidJson},
+	} {
+		httpmock.Reset()
+		registerResponders(test.primary, test.backup, test.opts)
+
+		res, err := fetch.Fetch(validEnvkeySimple, test.opts)
+		if test.expectErr == nil {
+			assert.Nil(t, err, test.desc)
+			assert.Equal(t, validResult, res, test.desc)
+		} else {
+			assert.True(t, errors.Is(err, test.expectErr), test.desc+": %v", err)
+			assert.Equal(t, "", res, test.desc)
+		}
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	strictOpts := fetch.FetchOptions{StrictResponse: true}
+
+	for _, test := range []struct {
+		desc      string
+		opts      fetch.FetchOptions
+		body      string
+		expectErr error
+	}{
+		{"valid json", strictOpts, responseSimple, nil},
+		{"unknown field", fetch.FetchOptions{}, responseUnknownField, nil},
+		{"too large", fetch.FetchOptions{MaxBodyBytes: 100}, responseSimple, fetch.ErrBodyTooLarge},
+		{"junk json", fetch.FetchOptions{}, "not json", fetch.ErrInvalidJson},
+		{"missing fields", fetch.FetchOptions{}, `{"env":"x"}`, fetch.ErrInvalidResponse},
+		{"strict, unknown field", strictOpts, responseUnknownField, fetch.ErrInvalidJson},
+		{"strict, duplicate field", strictOpts, responseDuplicateField, fetch.ErrInvalidJson},
+	} {
+		response, err := fetch.DecodeResponse([]byte(test.body), test.opts)
+		if test.expectErr == nil {
+			assert.Nil(t, err, test.desc)
+			assert.NotNil(t, response, test.desc)
+		} else {
+			assert.True(t, errors.Is(err, test.expectErr), test.desc+": %v", err)
+			assert.Nil(t, response, test.desc)
+		}
+	}
+}
+
+func TestInvalidResponseFallsBackToCache(t *testing.T) {
+	assert := assert.New(t)
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	c, _ := cache.NewCache(testCacheDir)
+	c.Write("validkey", []byte(strings.Split(validEnvkeySimple, "-")[1]), &cache.Entry{FetchedAt: time.Now(), Body: []byte(responseSimple)})
+	defer c.Delete("validkey")
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, ShouldCache: true, CacheDir: testCacheDir}
+	url := fetch.UrlWithLoggingParams("https://"+customRemoteHost+"/v"+strconv.Itoa(fetch.ApiVersion)+"/validkey", opts)
+	httpmock.RegisterResponder("GET", url, contentTypeResponder(http.StatusOK, "text/html", "<html>captive portal</html>"))
+
+	res, err := fetch.Fetch(validEnvkeySimple+"-"+customRemoteHost, opts)
+	assert.Nil(err)
+	assert.Equal(validResult, res, "Should load from cache when a custom host returns an invalid response.")
+}
+
+func TestBackupNotFound(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, ShouldCache: true, CacheDir: testCacheDir}
+	apiVersion := strconv.Itoa(fetch.ApiVersion)
+	restrictedUrl := fetch.UrlWithLoggingParams(fmt.Sprintf("%s?v=%s&id=%s", "https://"+fetch.BackupHostRestricted, apiVersion, "validkey"), opts)
+
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+	notFound := contentTypeResponder(http.StatusNotFound, "", "")
+
+	for _, test := range []struct {
+		desc             string
+		backup           httpmock.Responder
+		restrictedBackup httpmock.Responder
+		expectCleared    bool
+	}{
+		{"one backup not found", notFound, unavailable, false},
+		{"all backups not found", notFound, notFound, true},
+	} {
+		c, _ := cache.NewCache(testCacheDir)
+		c.Write("validkey", []byte(strings.Split(validEnvkeySimple, "-")[1]), &cache.Entry{FetchedAt: time.Now(), Body: []byte(responseSimple)})
+
+		httpmock.Reset()
+		registerResponders(unavailable, test.backup, opts)
+		// registered again to override the restricted backup url
+		httpmock.RegisterResponder("GET", restrictedUrl, test.restrictedBackup)
+
+		res, err := fetch.Fetch(validEnvkeySimple, opts)
+		if test.expectCleared {
+			assert.NotNil(t, err, test.desc)
+			_, err = c.Read("validkey", []byte(strings.Split(validEnvkeySimple, "-")[1]))
+			assert.NotNil(t, err, test.desc+": should clear the cache.")
+		} else {
+			assert.Nil(t, err, test.desc)
+			assert.Equal(t, validResult, res, test.desc+": should load from the cache without clearing it.")
+		}
+
+		c.Delete("validkey")
+	}
+}
@@ -0,0 +1,99 @@
+package fetch_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/fetch"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCAStrategies(t *testing.T) {
+	assert := assert.New(t)
+	server := newTLSEnvServer()
+	defer server.Close()
+	defer func() { fetch.Client = nil }()
+
+	caFile := writeCAFile(server)
+	defer os.Remove(caFile)
+
+	envkey := validEnvkeySimple + "-example.com"
+
+	// the test server's cert is only trusted when it's supplied as a CA file
+	for _, test := range []struct {
+		strategy  string
+		caFile    string
+		expectErr bool
+	}{
+		{fetch.CAStrategyFile, caFile, false},
+		{fetch.CAStrategyAuto, caFile, false},
+		{fetch.CAStrategyAuto, "", true},
+		{fetch.CAStrategyMozilla, "", true},
+	} {
+		opts := fetch.FetchOptions{TimeoutSeconds: 2.0, CAStrategy: test.strategy, CAFile: test.caFile}
+		assert.Nil(fetch.InitHttpClientWithOptions(opts))
+		routeToServer(server)
+
+		res, err := fetch.Fetch(envkey, opts)
+		if test.expectErr {
+			assert.NotNil(err, test.strategy+" should not trust the test server")
+		} else {
+			assert.Nil(err, test.strategy+" should trust the test server")
+			assert.Equal(validResult, res)
+		}
+	}
+}
+
+func TestInvalidCAStrategies(t *testing.T) {
+	defer func() { fetch.Client = nil }()
+
+	assert.NotNil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{CAStrategy: fetch.CAStrategyFile}), "file strategy requires a CA file")
+	assert.NotNil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{CAStrategy: fetch.CAStrategyFile, CAFile: "/nonexistent/ca.pem"}), "CA file must exist")
+	assert.NotNil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{CAStrategy: "bogus"}), "unknown strategy")
+
+	// not a certificate
+	f, _ := ioutil.TempFile("", "envkey-fetch-ca")
+	f.WriteString("not a cert")
+	f.Close()
+	defer os.Remove(f.Name())
+	assert.NotNil(t, fetch.InitHttpClientWithOptions(fetch.FetchOptions{CAStrategy: fetch.CAStrategyFile, CAFile: f.Name()}), "CA file must contain certificates")
+}
+
+// Run with -race: backups are fetched concurrently and the client must not be mutated while they're in flight.
+func TestConcurrentBackupFetch(t *testing.T) {
+	server := newTLSEnvServer()
+	defer server.Close()
+	defer func() { fetch.Client = nil }()
+
+	caFile := writeCAFile(server)
+	defer os.Remove(caFile)
+
+	defaultHost, backupHost, backupHostRestricted := fetch.DefaultHost, fetch.BackupHost, fetch.BackupHostRestricted
+	defer func() {
+		fetch.DefaultHost, fetch.BackupHost, fetch.BackupHostRestricted = defaultHost, backupHost, backupHostRestricted
+	}()
+
+	// primary is unreachable, so both backups are requested at once
+	fetch.DefaultHost = "localhost:61034"
+	fetch.BackupHost = "example.com/backup"
+	fetch.BackupHostRestricted = "example.com/restricted"
+
+	opts := fetch.FetchOptions{TimeoutSeconds: 2.0, CAStrategy: fetch.CAStrategyFile, CAFile: caFile}
+	assert.Nil(t, fetch.InitHttpClientWithOptions(opts))
+	routeToServer(server)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := fetch.Fetch(validEnvkeySimple, opts)
+			assert.Nil(t, err)
+			assert.Equal(t, validResult, res, "Should load from backup concurrently.")
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,48 @@
+package fetch_test
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/envkey/envkey-fetch/fetch"
+)
+
+// newTLSEnvServer serves the simple response for "validkey" at the primary and backup paths. Its certificate is valid for example.com.
+func newTLSEnvServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/validkey", "/backup/v1/validkey":
+			w.Write([]byte(responseSimple))
+		case "/restricted":
+			if r.URL.Query().Get("id") == "validkey" {
+				w.Write([]byte(responseSimple))
+			} else {
+				http.NotFound(w, r)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// writeCAFile writes the server's self-signed certificate to a temporary PEM file and returns its path.
+func writeCAFile(server *httptest.Server) string {
+	f, _ := ioutil.TempFile("", "envkey-fetch-ca")
+	defer f.Close()
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return f.Name()
+}
+
+// routeToServer sends connections made by fetch.Client to example.com to the server.
+func routeToServer(server *httptest.Server) {
+	fetch.Client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "example.com:443" {
+			addr = server.Listener.Addr().String()
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+}
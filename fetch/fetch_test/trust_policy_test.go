@@ -0,0 +1,73 @@
+package fetch_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/fetch"
+	"github.com/envkey/envkey-fetch/internal/fixtures"
+	"github.com/envkey/envkey-fetch/trust"
+	"github.com/jarcoal/httpmock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustPolicy(t *testing.T) {
+	fetch.InitHttpClient(2.0)
+	httpmock.ActivateNonDefault(fetch.Client)
+	defer httpmock.DeactivateAndReset()
+	defer func() { fetch.Client = nil }()
+
+	org, err := fixtures.NewOrg()
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := org.Response(map[string]string{"GO_TEST": "it"}, true)
+	signerFingerprint := org.SignerFingerprint()
+
+	dir, err := ioutil.TempDir("", "envkey-trust-policy")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	writePolicy := func(policy string) string {
+		path := filepath.Join(dir, "trust_policy.json")
+		ioutil.WriteFile(path, []byte(policy), 0600)
+		return path
+	}
+
+	unavailable := contentTypeResponder(http.StatusServiceUnavailable, "", "")
+	fetchWithPolicy := func(opts fetch.FetchOptions) error {
+		httpmock.Reset()
+		opts.TimeoutSeconds = 2.0
+		opts.NoBackup = true
+		registerResponders(httpmock.NewBytesResponder(http.StatusOK, body), unavailable, opts)
+		_, err := fetch.Fetch("validkey-anypassphrase", opts)
+		return err
+	}
+
+	// the fixture signer is its own root
+	err = fetchWithPolicy(fetch.FetchOptions{TrustPolicyFile: writePolicy(`{"default":{"root_fingerprints":["` + signerFingerprint + `"]}}`)})
+	assert.Nil(t, err)
+
+	otherOrg, _ := fixtures.NewOrg()
+	otherFingerprint := otherOrg.SignerFingerprint()
+	err = fetchWithPolicy(fetch.FetchOptions{TrustPolicyFile: writePolicy(`{"default":{"root_fingerprints":["` + otherFingerprint + `"]}}`)})
+	assert.True(t, errors.Is(err, trust.ErrPolicy), "Should be rejected by policy.")
+
+	// a policy for this ENVKEY replaces the default
+	err = fetchWithPolicy(fetch.FetchOptions{TrustPolicyFile: writePolicy(`{"default":{"deny_signer_ids":["` + org.SignerId + `"]},"envkeys":{"validkey":{}}}`)})
+	assert.Nil(t, err)
+
+	err = fetchWithPolicy(fetch.FetchOptions{TrustPolicy: &trust.Policy{DenySignerFingerprints: []string{signerFingerprint}}})
+	assert.True(t, errors.Is(err, trust.ErrPolicy), "Should be rejected by policy.")
+
+	// an explicit policy file has to exist
+	err = fetchWithPolicy(fetch.FetchOptions{TrustPolicyFile: filepath.Join(dir, "missing.json")})
+	assert.NotNil(t, err)
+}
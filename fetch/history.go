@@ -0,0 +1,123 @@
+package fetch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/envkey/envkey-fetch/parser"
+	"github.com/envkey/envkey-fetch/trust"
+)
+
+// Generation describes a cached generation of an env. It only holds key names, never values.
+type Generation struct {
+	FetchedAt time.Time
+	SourceUrl string
+	SignerId  string
+	Keys      []string
+
+	// compared with the next older generation; unset for the oldest one
+	Added   []string
+	Removed []string
+	Changed []string
+
+	// set if the generation couldn't be verified and decrypted
+	Err error
+}
+
+// History verifies and decrypts each cached generation of envkey's env, newest first, and compares the keys in each with the one before. Nothing is fetched.
+func History(envkey string, options FetchOptions) ([]*Generation, error) {
+	if len(strings.Split(envkey, "-")) < 2 {
+		return nil, errors.New("ENVKEY invalid")
+	}
+	envkeyParam, pw, _, free := splitEnvkey(envkey, options)
+	defer free()
+
+	trustPolicy, err := LoadTrustPolicy(envkey, options)
+	if err != nil {
+		return nil, err
+	}
+
+	fetchCache, err := newCache(options)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := fetchCache.History(envkeyParam, pw)
+	if err != nil {
+		return nil, err
+	}
+
+	generations := make([]*Generation, len(entries))
+	envs := make([]map[string]interface{}, len(entries))
+	for i, entry := range entries {
+		generations[i] = &Generation{FetchedAt: entry.FetchedAt, SourceUrl: entry.SourceUrl}
+		generations[i].SignerId, envs[i], generations[i].Err = decryptEntry(entry.Body, pw, trustPolicy)
+		generations[i].Keys = sortedKeys(envs[i])
+	}
+
+	for i := 0; i < len(generations)-1; i++ {
+		if envs[i] != nil && envs[i+1] != nil {
+			generations[i].Added, generations[i].Removed, generations[i].Changed = diffKeys(envs[i+1], envs[i])
+		}
+	}
+
+	return generations, nil
+}
+
+func decryptEntry(body []byte, pw []byte, trustPolicy *trust.Policy) (string, map[string]interface{}, error) {
+	response := new(parser.EnvServiceResponse)
+	err := decodeResponse(body, false, response)
+	if err != nil {
+		return "", nil, err
+	}
+
+	verifiedEnv, err := response.ParseVerifiedWithPolicy(pw, trustPolicy)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var env map[string]interface{}
+	err = json.Unmarshal([]byte(verifiedEnv.Json), &env)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return verifiedEnv.SignerId, env, nil
+}
+
+// diffKeys returns the keys added, removed and changed from older to newer.
+func diffKeys(older, newer map[string]interface{}) ([]string, []string, []string) {
+	added, removed, changed := []string{}, []string{}, []string{}
+
+	for key, value := range newer {
+		olderValue, ok := older[key]
+		if !ok {
+			added = append(added, key)
+		} else if !reflect.DeepEqual(value, olderValue) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range older {
+		if _, ok := newer[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+func sortedKeys(env map[string]interface{}) []string {
+	keys := []string{}
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
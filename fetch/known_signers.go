@@ -0,0 +1,254 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/parser"
+	"github.com/envkey/envkey-fetch/trust"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const (
+	KnownSignersOff    = "off"
+	KnownSignersWarn   = "warn"
+	KnownSignersStrict = "strict"
+)
+
+const DefaultKnownSignersFile = "~/.envkey/known_signers"
+
+var ErrSignersChanged = errors.New("root or signer keys changed since they were first seen")
+
+// SignersChangedError lists the root and signer keys that changed. It matches ErrSignersChanged.
+type SignersChangedError struct {
+	Changes []*trust.Change
+}
+
+func (e *SignersChangedError) Error() string {
+	changes := []string{}
+	for _, change := range e.Changes {
+		changes = append(changes, change.String())
+	}
+	return fmt.Sprintf("%s: %s. If this is expected, approve it with `envkey-fetch trust accept`", ErrSignersChanged.Error(), strings.Join(changes, ", "))
+}
+
+func (e *SignersChangedError) Is(target error) bool {
+	return target == ErrSignersChanged
+}
+
+func validateKnownSignersPolicy(options FetchOptions) error {
+	switch options.KnownSigners {
+	case "", KnownSignersOff, KnownSignersWarn, KnownSignersStrict:
+		return nil
+	default:
+		return errors.New("unknown known signers policy: " + options.KnownSigners)
+	}
+}
+
+func knownSignersEnabled(options FetchOptions) bool {
+	return options.KnownSigners == KnownSignersWarn || options.KnownSigners == KnownSignersStrict
+}
+
+// checkKnownSigners records the root and signer keys of the first verified response for an ENVKEY, trust on first use style. After that, a new or changed root or a signer whose key changed is printed as a warning, or fails the fetch with the "strict" policy, until it's approved with AcceptSigners.
+func checkKnownSigners(envkeyParam string, verifiedEnv *parser.VerifiedEnv, options FetchOptions) error {
+	if !knownSignersEnabled(options) {
+		return nil
+	}
+
+	path, err := knownSignersPath(options)
+	if err != nil {
+		return err
+	}
+
+	// without the lock keys are still checked, but not recorded, since a failed write doesn't fail the fetch either
+	unlock, lockErr := lockKnownSigners(path)
+	if lockErr == nil {
+		defer unlock()
+	} else if options.VerboseOutput {
+		fmt.Fprintln(os.Stderr, "Error locking known signers:")
+		fmt.Fprintln(os.Stderr, lockErr)
+	}
+
+	knownSigners, err := readKnownSigners(path)
+	if err != nil {
+		return err
+	}
+
+	name := cache.Filename(envkeyParam)
+	seen := seenSigners(verifiedEnv)
+
+	known, ok := knownSigners[name]
+	if !ok {
+		if lockErr == nil {
+			if options.VerboseOutput {
+				fmt.Fprintf(os.Stderr, "Recording root and signer keys in %s\n", path)
+			}
+			knownSigners[name] = seen
+			writeKnownSigners(path, knownSigners, options)
+		}
+		return nil
+	}
+
+	alerts, updates := known.Check(seen)
+	if len(alerts) > 0 {
+		err = &SignersChangedError{Changes: alerts}
+		if options.KnownSigners == KnownSignersStrict {
+			return err
+		}
+		// not recorded, so the warning repeats until the change is accepted
+		fmt.Fprintln(os.Stderr, "Warning: "+err.Error())
+		return nil
+	}
+
+	for _, update := range updates {
+		if update.Seen != "" && lockErr == nil {
+			known.Merge(seen)
+			writeKnownSigners(path, knownSigners, options)
+			break
+		}
+	}
+	return nil
+}
+
+// AcceptSigners fetches and verifies envkey like Fetch, then records its root and signer keys as known, replacing the roots known before. It returns what changed.
+// Only a fresh response from the server or its backups is accepted, never a cached one, which could be older than the change being approved.
+func AcceptSigners(envkey string, options FetchOptions) ([]*trust.Change, error) {
+	if options.Offline || options.Rollback > 0 {
+		return nil, errors.New("accepting signers needs a fresh response, so it can't be combined with offline or rollback")
+	}
+
+	// accepting shouldn't be blocked by the change being accepted
+	options.KnownSigners = KnownSignersOff
+	options.RequireFresh = true
+	options.PreferCache = false
+	options.Coalesce = false
+
+	options, policy, err := prepare(envkey, options)
+	if err != nil {
+		return nil, err
+	}
+
+	envkeyParam, pw, envkeyHost, free := splitEnvkey(envkey, options)
+	defer free()
+
+	response, _, err := fetchEnv(envkeyParam, pw, envkeyHost, options, policy, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	verifiedEnv, err := response.ParseVerifiedWithPolicy(pw, options.TrustPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := knownSignersPath(options)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockKnownSigners(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	knownSigners, err := readKnownSigners(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := cache.Filename(envkeyParam)
+	known, ok := knownSigners[name]
+	if !ok {
+		known = trust.NewKnown()
+		knownSigners[name] = known
+	}
+	changes := known.Accept(seenSigners(verifiedEnv))
+
+	return changes, saveKnownSigners(path, knownSigners)
+}
+
+func seenSigners(verifiedEnv *parser.VerifiedEnv) *trust.Known {
+	seen := trust.NewKnown()
+	for id, fingerprint := range verifiedEnv.RootFingerprints {
+		seen.Roots[id] = fingerprint
+	}
+	seen.Signers[verifiedEnv.SignerId] = verifiedEnv.SignerFingerprint
+	if verifiedEnv.InheritanceOverridesSignerId != "" {
+		seen.Signers[verifiedEnv.InheritanceOverridesSignerId] = verifiedEnv.InheritanceOverridesSignerFingerprint
+	}
+	return seen
+}
+
+func knownSignersPath(options FetchOptions) (string, error) {
+	path := options.KnownSignersFile
+	if path == "" {
+		path = DefaultKnownSignersFile
+	}
+	return homedir.Expand(path)
+}
+
+// lockKnownSigners serializes reading, changing and saving the known signers file between processes, so concurrent fetches don't drop each other's changes.
+func lockKnownSigners(path string) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	return cache.LockPath(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock"))
+}
+
+// readKnownSigners returns an empty set if the file doesn't exist yet.
+func readKnownSigners(path string) (trust.KnownSigners, error) {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return trust.KnownSigners{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	knownSigners, err := trust.ParseKnownSigners(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return knownSigners, nil
+}
+
+// A failed write doesn't fail the fetch, since the keys are checked again next time.
+func writeKnownSigners(path string, knownSigners trust.KnownSigners, options FetchOptions) {
+	err := saveKnownSigners(path, knownSigners)
+	if err != nil && options.VerboseOutput {
+		fmt.Fprintln(os.Stderr, "Error writing known signers:")
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// saveKnownSigners replaces the file in one step, so readers never see it partly written. Callers hold the lock from lockKnownSigners.
+func saveKnownSigners(path string, knownSigners trust.KnownSigners) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(knownSigners.Format())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,110 @@
+package fetch
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultPins are SPKI pins ("sha256/{base64 hash}") by host that are merged with any pins passed in FetchOptions.Pins. None are shipped, so hosts are only pinned when pins are passed in or a build sets them here. A build that sets them should pin a backup key for each host as well, so the server can rotate keys without breaking clients.
+var DefaultPins = map[string][]string{}
+
+const pinPrefix = "sha256/"
+
+type PinMismatchError struct {
+	Host   string
+	Pinned []string
+	Served []string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("TLS public key pin mismatch for %s: expected one of %s, got %s", e.Host, strings.Join(e.Pinned, ", "), strings.Join(e.Served, ", "))
+}
+
+// SpkiPin returns the "sha256/{base64 hash}" pin for a certificate's public key.
+func SpkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parsePins merges DefaultPins with pins in the form "host=sha256/{base64 hash}".
+func parsePins(rawPins []string) (map[string][]string, error) {
+	pins := map[string][]string{}
+
+	for host, hostPins := range DefaultPins {
+		for _, pin := range hostPins {
+			if err := validatePin(pin); err != nil {
+				return nil, err
+			}
+			pins[strings.ToLower(host)] = append(pins[strings.ToLower(host)], pin)
+		}
+	}
+
+	for _, rawPin := range rawPins {
+		split := strings.SplitN(rawPin, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, errors.New("invalid pin (expected host=sha256/...): " + rawPin)
+		}
+
+		host, pin := strings.ToLower(strings.TrimSpace(split[0])), strings.TrimSpace(split[1])
+		if err := validatePin(pin); err != nil {
+			return nil, err
+		}
+		pins[host] = append(pins[host], pin)
+	}
+
+	return pins, nil
+}
+
+func validatePin(pin string) error {
+	if !strings.HasPrefix(pin, pinPrefix) {
+		return errors.New("invalid pin (only sha256 is supported): " + pin)
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+	if err != nil || len(hash) != sha256.Size {
+		return errors.New("invalid pin (expected a base64 encoded sha256 hash): " + pin)
+	}
+
+	return nil
+}
+
+// pinVerifier checks that at least one certificate in the verified chain matches a pin for the host. Hosts without pins aren't checked.
+// Pins are matched against the TLS server name, so they apply to hostnames rather than IP addresses.
+func pinVerifier(pins map[string][]string, options FetchOptions) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		host := strings.ToLower(cs.ServerName)
+		hostPins, ok := pins[host]
+		if !ok {
+			return nil
+		}
+
+		var served []string
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				servedPin := SpkiPin(cert)
+				for _, pin := range hostPins {
+					if pin == servedPin {
+						return nil
+					}
+				}
+				served = append(served, servedPin)
+			}
+		}
+
+		err := &PinMismatchError{host, hostPins, served}
+
+		// a mismatch is always reported, since report only mode is pointless if it's hidden without --verbose
+		if options.PinReportOnly {
+			fmt.Fprintln(os.Stderr, "Warning: "+err.Error()+" (report only)")
+			return nil
+		}
+
+		return err
+	}
+}
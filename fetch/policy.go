@@ -0,0 +1,137 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+)
+
+var (
+	ErrStaleCache     = errors.New("cache entry too old")
+	ErrCacheNotOutage = errors.New("cache only used when servers are unreachable")
+)
+
+// sourcePolicy decides which sources getJson may load from. Every source restriction in FetchOptions is applied here.
+type sourcePolicy struct {
+	network           bool
+	backup            bool
+	cache             bool
+	cacheOnlyOnOutage bool
+	maxStale          time.Duration
+	rollback          int
+}
+
+func newSourcePolicy(options FetchOptions) (sourcePolicy, error) {
+	conflicts := []struct {
+		a, b         bool
+		aFlag, bFlag string
+	}{
+		{options.Offline, options.RequireFresh, "offline", "require-fresh"},
+		{options.Offline, options.CacheOnlyOnOutage, "offline", "cache-only-on-outage"},
+		{options.Offline, options.CrossCheck, "offline", "cross-check"},
+		{options.Offline, options.PreferCache, "offline", "prefer-cache"},
+		{options.NoBackup, options.CrossCheck, "no-backup", "cross-check"},
+		{options.RequireFresh, options.PreferCache, "require-fresh", "prefer-cache"},
+		{options.Rollback > 0, options.RequireFresh, "rollback", "require-fresh"},
+		{options.Rollback > 0, options.CacheOnlyOnOutage, "rollback", "cache-only-on-outage"},
+		{options.Rollback > 0, options.CrossCheck, "rollback", "cross-check"},
+		{options.Rollback > 0, options.PreferCache, "rollback", "prefer-cache"},
+		{options.Offline, options.Coalesce, "offline", "coalesce"},
+		{options.Rollback > 0, options.Coalesce, "rollback", "coalesce"},
+		{options.RequireFresh, options.Coalesce, "require-fresh", "coalesce"},
+	}
+	for _, c := range conflicts {
+		if c.a && c.b {
+			return sourcePolicy{}, fmt.Errorf("%s can't be combined with %s", c.aFlag, c.bFlag)
+		}
+	}
+
+	if options.MaxStale < 0 {
+		return sourcePolicy{}, errors.New("max-stale can't be negative")
+	}
+	if options.Rollback < 0 {
+		return sourcePolicy{}, errors.New("rollback can't be negative")
+	}
+
+	// rolling back only ever reads the cache
+	offline := options.Offline || options.Rollback > 0
+
+	return sourcePolicy{
+		network:           !offline,
+		backup:            !offline && !options.NoBackup,
+		cache:             options.ShouldCache && !options.RequireFresh,
+		cacheOnlyOnOutage: options.CacheOnlyOnOutage,
+		maxStale:          options.MaxStale,
+		rollback:          options.Rollback,
+	}, nil
+}
+
+// sources lists what may be loaded from, in the order it's tried.
+func (policy sourcePolicy) sources() []string {
+	sources := []string{}
+	if policy.network {
+		sources = append(sources, SourcePrimary)
+	}
+	if policy.backup {
+		sources = append(sources, SourceBackup)
+	}
+	if policy.cache {
+		sources = append(sources, SourceCache)
+	}
+	return sources
+}
+
+// result describes an entry loaded from source. Json is set once it's verified.
+func (policy sourcePolicy) result(source string, entry *cache.Entry) *FetchResult {
+	return &FetchResult{
+		Source:         source,
+		SourceUrl:      entry.SourceUrl,
+		FetchedAt:      entry.FetchedAt,
+		AllowedSources: policy.sources(),
+		entry:          entry,
+	}
+}
+
+// describe names the allowed sources for error messages, e.g. "server, s3 backup, or cache".
+func (policy sourcePolicy) describe() string {
+	names := map[string]string{SourcePrimary: "server", SourceBackup: "s3 backup", SourceCache: "cache"}
+
+	sources := []string{}
+	for _, source := range policy.sources() {
+		sources = append(sources, names[source])
+	}
+
+	switch len(sources) {
+	case 0:
+		return "nowhere"
+	case 1:
+		return sources[0]
+	case 2:
+		return sources[0] + " or " + sources[1]
+	default:
+		return strings.Join(sources[:len(sources)-1], ", ") + ", or " + sources[len(sources)-1]
+	}
+}
+
+// checkCacheFallback is called once the network sources have failed with fetchErr. With cache-only-on-outage, a server that responded with a client error isn't an outage.
+func (policy sourcePolicy) checkCacheFallback(fetchErr error) error {
+	if policy.cacheOnlyOnOutage && fetchErr != nil && !isOutage(fetchErr) {
+		return ErrCacheNotOutage
+	}
+	return nil
+}
+
+func (policy sourcePolicy) checkCacheAge(entry *cache.Entry) error {
+	if policy.maxStale == 0 {
+		return nil
+	}
+
+	age := time.Since(entry.FetchedAt)
+	if age > policy.maxStale {
+		return fmt.Errorf("%w: fetched %s ago, max-stale is %s", ErrStaleCache, age.Round(time.Second), policy.maxStale)
+	}
+	return nil
+}
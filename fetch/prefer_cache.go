@@ -0,0 +1,154 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/envkey/envkey-fetch/parser"
+)
+
+const DefaultCacheMaxAge = 24 * time.Hour
+
+var ErrRefreshTimeout = errors.New("background refresh timed out")
+
+// RefreshFunc receives the result of fetching from the network. See FetchWithRefresh.
+type RefreshFunc func(res string, err error)
+
+type refreshResult struct {
+	res string
+	err error
+}
+
+func cacheMaxAge(options FetchOptions) time.Duration {
+	if options.CacheMaxAge > 0 {
+		return options.CacheMaxAge
+	}
+	return DefaultCacheMaxAge
+}
+
+// refreshTimeout defaults to the request timeout. A refresh only makes a single attempt, but it may still need the backup urls.
+func refreshTimeout(options FetchOptions) time.Duration {
+	if options.RefreshTimeout > 0 {
+		return options.RefreshTimeout
+	}
+	if options.TimeoutSeconds > 0 {
+		return time.Duration(options.TimeoutSeconds * float64(time.Second))
+	}
+	return 10 * time.Second
+}
+
+// fetchPreferCache returns the cached env and starts a background refresh if there's a valid cache entry younger than the max age. ok is false if the cache can't be used.
+func fetchPreferCache(envkey string, options FetchOptions, policy sourcePolicy, onRefresh RefreshFunc) (*FetchResult, bool) {
+	envkeyParam, pw, _, free := splitEnvkey(envkey, options)
+	defer free()
+
+	fetchCache, err := newCache(options)
+	if err != nil {
+		if options.VerboseOutput {
+			fmt.Fprintf(os.Stderr, "Error initializing cache: %s\n", err.Error())
+		}
+		return nil, false
+	}
+
+	entry, err := fetchCache.Read(envkeyParam, pw)
+	if err == nil {
+		err = policy.checkCacheAge(entry)
+	}
+	if err != nil {
+		if options.VerboseOutput {
+			fmt.Fprintln(os.Stderr, "No usable cache entry, fetching:")
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return nil, false
+	}
+
+	age := time.Since(entry.FetchedAt)
+	if age > cacheMaxAge(options) {
+		if options.VerboseOutput {
+			fmt.Fprintf(os.Stderr, "Cache entry is %s old, older than the max age of %s, fetching.\n", age.Round(time.Second), cacheMaxAge(options))
+		}
+		return nil, false
+	}
+
+	verifiedEnv, err := verifyCached(envkeyParam, entry.Body, pw, options)
+	if err != nil {
+		if options.VerboseOutput {
+			fmt.Fprintln(os.Stderr, "Cache entry invalid, fetching:")
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return nil, false
+	}
+
+	if options.VerboseOutput {
+		fmt.Fprintf(os.Stderr, "Loaded from cache (fetched %s ago), refreshing in background.\n", age.Round(time.Second))
+	}
+
+	go refresh(envkey, options, onRefresh)
+
+	result := policy.result(SourceCache, entry)
+	result.Json = verifiedEnv.Json
+	return result, true
+}
+
+// verifyCached decodes, verifies and decrypts a cached response body, then checks its keys against the known signers.
+func verifyCached(envkeyParam string, body []byte, pw []byte, options FetchOptions) (*parser.VerifiedEnv, error) {
+	response := new(parser.EnvServiceResponse)
+	err := decodeResponse(body, false, response)
+	if err != nil {
+		return nil, err
+	}
+
+	verifiedEnv, err := response.ParseVerifiedWithPolicy(pw, options.TrustPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkKnownSigners(envkeyParam, verifiedEnv, options)
+	if err != nil {
+		return nil, err
+	}
+	return verifiedEnv, nil
+}
+
+// refresh fetches with a single attempt and no cache fallback (as with RequireFresh), giving up after the refresh timeout. A fetch still in flight at that point may still update the cache.
+func refresh(envkey string, options FetchOptions, onRefresh RefreshFunc) {
+	refreshOptions := options
+	refreshOptions.PreferCache = false
+	refreshOptions.Retries = 0
+	refreshOptions.RequireFresh = true
+
+	// can't fail, since prefer-cache was already checked against the other options
+	policy, _ := newSourcePolicy(refreshOptions)
+
+	resultChan := make(chan refreshResult, 1)
+	go func() {
+		result, err := fetchFresh(envkey, refreshOptions, policy)
+		if err != nil {
+			resultChan <- refreshResult{"", err}
+			return
+		}
+		resultChan <- refreshResult{result.Json, nil}
+	}()
+
+	var result refreshResult
+	select {
+	case result = <-resultChan:
+	case <-time.After(refreshTimeout(options)):
+		result = refreshResult{"", ErrRefreshTimeout}
+	}
+
+	if options.VerboseOutput {
+		if result.err == nil {
+			fmt.Fprintln(os.Stderr, "Background refresh finished.")
+		} else {
+			fmt.Fprintln(os.Stderr, "Background refresh failed:")
+			fmt.Fprintln(os.Stderr, result.err)
+		}
+	}
+
+	if onRefresh != nil {
+		onRefresh(result.res, result.err)
+	}
+}
@@ -0,0 +1,98 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// proxyFunc returns the Transport's Proxy func. Without an explicit proxy, HTTP_PROXY/HTTPS_PROXY/NO_PROXY are honored.
+func proxyFunc(options FetchOptions) (func(*http.Request) (*url.URL, error), error) {
+	if options.Proxy == "" {
+		if options.ProxyCredentialsFile != "" {
+			return nil, errors.New("a proxy credentials file requires a proxy")
+		}
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyUrl, err := url.Parse(options.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.New("unsupported proxy scheme (expected http, https, or socks5): " + proxyUrl.Scheme)
+	}
+
+	if proxyUrl.Host == "" {
+		return nil, errors.New("invalid proxy: " + options.Proxy)
+	}
+
+	if options.ProxyCredentialsFile != "" {
+		proxyUrl.User, err = readProxyCredentials(options.ProxyCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.VerboseOutput {
+		fmt.Fprintf(os.Stderr, "Using proxy %s\n", proxyUrl.Redacted())
+	}
+
+	return http.ProxyURL(proxyUrl), nil
+}
+
+// readProxyCredentials reads "username:password" from a file, so credentials don't end up in the process list or shell history.
+func readProxyCredentials(path string) (*url.Userinfo, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	split := strings.SplitN(strings.TrimSpace(string(b)), ":", 2)
+	if len(split) != 2 || split[0] == "" {
+		return nil, errors.New("invalid proxy credentials file (expected username:password): " + path)
+	}
+
+	return url.UserPassword(split[0], split[1]), nil
+}
+
+// requestHeader builds the headers sent with primary and backup requests from "Name: value" pairs, plus client metadata if options.MetadataHeaders is set.
+func requestHeader(options FetchOptions) (http.Header, error) {
+	header := http.Header{}
+
+	for _, rawHeader := range options.Headers {
+		split := strings.SplitN(rawHeader, ":", 2)
+		name := strings.TrimSpace(split[0])
+		if len(split) != 2 || name == "" || strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(split[1], "\r\n") {
+			return nil, errors.New("invalid header (expected Name: value): " + rawHeader)
+		}
+		header.Add(textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(split[1]))
+	}
+
+	if options.MetadataHeaders {
+		clientName, clientVersion := clientMetadata(options)
+		header.Set("X-Envkey-Client-Name", clientName)
+		header.Set("X-Envkey-Client-Version", clientVersion)
+		header.Set("X-Envkey-Client-Os", runtime.GOOS)
+		header.Set("X-Envkey-Client-Arch", runtime.GOARCH)
+	}
+
+	return header, nil
+}
+
+// requestUrl adds client metadata to the url as query params, unless it's being sent as headers.
+func requestUrl(baseUrl string, options FetchOptions) string {
+	if options.MetadataHeaders {
+		return baseUrl
+	}
+	return UrlWithLoggingParams(baseUrl, options)
+}
@@ -0,0 +1,50 @@
+package fetch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/parser"
+)
+
+const (
+	ReplayPolicyOff    = "off"
+	ReplayPolicyWarn   = "warn"
+	ReplayPolicyRefuse = "refuse"
+)
+
+func validateReplayPolicy(options FetchOptions) error {
+	switch options.ReplayPolicy {
+	case "", ReplayPolicyOff, ReplayPolicyWarn, ReplayPolicyRefuse:
+		return nil
+	default:
+		return errors.New("unknown replay policy: " + options.ReplayPolicy)
+	}
+}
+
+func replayProtected(options FetchOptions) bool {
+	return options.ReplayPolicy == ReplayPolicyWarn || options.ReplayPolicy == ReplayPolicyRefuse
+}
+
+// checkReplay records a verified response in the cache's version log and catches an older response being served again after a newer one, as a compromised mirror or MITM could. With the "warn" policy, problems are printed instead of failing the fetch.
+func checkReplay(fetchCache *cache.Cache, envkeyParam string, pw []byte, response *parser.EnvServiceResponse, options FetchOptions) error {
+	err := fetchCache.CheckVersion(envkeyParam, pw, replayPayload(response))
+	if err == nil {
+		return nil
+	}
+
+	if options.ReplayPolicy == ReplayPolicyWarn {
+		fmt.Fprintln(os.Stderr, "Warning: "+err.Error())
+		return nil
+	}
+	return err
+}
+
+// replayPayload is what identifies a version: the signed, encrypted env and inheritance overrides. Re-encrypting the same env makes a new version, so reverting a change isn't mistaken for a replay.
+func replayPayload(response *parser.EnvServiceResponse) []byte {
+	payload, _ := json.Marshal([]string{response.Env, response.InheritanceOverrides})
+	return payload
+}
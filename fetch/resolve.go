@@ -0,0 +1,96 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseResolve parses curl-style "host:port:addr" overrides into a map of "host:port" to the address that should be dialed instead.
+func parseResolve(rawResolve []string) (map[string]string, error) {
+	overrides := map[string]string{}
+
+	for _, raw := range rawResolve {
+		split := strings.SplitN(raw, ":", 3)
+		if len(split) != 3 || split[0] == "" {
+			return nil, errors.New("invalid resolve override (expected host:port:addr): " + raw)
+		}
+
+		host, port, addr := strings.ToLower(split[0]), split[1], strings.Trim(split[2], "[]")
+
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return nil, errors.New("invalid port in resolve override: " + raw)
+		}
+
+		if net.ParseIP(addr) == nil {
+			return nil, errors.New("invalid address in resolve override (expected an IP): " + raw)
+		}
+
+		overrides[net.JoinHostPort(host, port)] = net.JoinHostPort(addr, port)
+	}
+
+	return overrides, nil
+}
+
+// dnsServerAddr adds the default DNS port to a DNS server address without one.
+func dnsServerAddr(server string) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server, nil
+	}
+
+	if net.ParseIP(strings.Trim(server, "[]")) == nil {
+		return "", errors.New("invalid DNS server (expected ip or ip:port): " + server)
+	}
+
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53"), nil
+}
+
+// dialContext returns the Transport's DialContext func, which applies resolve overrides and uses options.DNSServer for lookups if set.
+func dialContext(options FetchOptions) (func(context.Context, string, string) (net.Conn, error), error) {
+	overrides, err := parseResolve(options.Resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(options.TimeoutSeconds) * time.Second
+	dialer := &net.Dialer{Timeout: timeout}
+
+	if options.DNSServer != "" {
+		server, err := dnsServerAddr(options.DNSServer)
+		if err != nil {
+			return nil, err
+		}
+
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: timeout}).DialContext(ctx, network, server)
+			},
+		}
+
+		if options.VerboseOutput {
+			fmt.Fprintf(os.Stderr, "Using DNS server %s\n", server)
+		}
+	}
+
+	if options.VerboseOutput {
+		for hostPort, addr := range overrides {
+			fmt.Fprintf(os.Stderr, "Resolve override: %s -> %s\n", hostPort, addr)
+		}
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if override, ok := overrides[strings.ToLower(addr)]; ok {
+			if options.VerboseOutput {
+				fmt.Fprintf(os.Stderr, "Connecting to %s at %s (resolve override)\n", addr, override)
+			}
+			addr = override
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}, nil
+}
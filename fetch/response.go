@@ -0,0 +1,226 @@
+package fetch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/envkey/envkey-fetch/cache"
+	"github.com/envkey/envkey-fetch/parser"
+)
+
+const DefaultMaxBodyBytes int64 = 10 * 1024 * 1024
+
+var (
+	ErrNotFound        = errors.New("ENVKEY not found")
+	ErrBodyTooLarge    = errors.New("response body too large")
+	ErrContentType     = errors.New("unexpected response content type")
+	ErrInvalidJson     = errors.New("invalid response json")
+	ErrInvalidResponse = errors.New("invalid response")
+)
+
+// Content types accepted outside of strict mode. S3 serves objects as octet streams unless told otherwise.
+var lenientContentTypes = map[string]bool{
+	"application/json":         true,
+	"text/plain":               true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+}
+
+// StatusError is returned for any response status other than 200. A 404 matches ErrNotFound.
+type StatusError struct {
+	Url        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return "response status " + strconv.Itoa(e.StatusCode) + " from " + e.Url
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// ResponseError is returned when a 200 response is too large, has the wrong content type, or doesn't decode into a valid EnvServiceResponse.
+type ResponseError struct {
+	Url string
+	Err error
+}
+
+func (e *ResponseError) Error() string {
+	return "invalid response from " + e.Url + ": " + e.Err.Error()
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+func maxBodyBytes(options FetchOptions) int64 {
+	if options.MaxBodyBytes > 0 {
+		return options.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// readResponseBody returns the validated body as a cache entry, ready to be written.
+func readResponseBody(r *http.Response, url string, options FetchOptions, response *parser.EnvServiceResponse) (*cache.Entry, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, &StatusError{url, r.StatusCode}
+	}
+
+	err := checkContentType(r.Header.Get("Content-Type"), options.StrictResponse)
+	if err != nil {
+		return nil, &ResponseError{url, err}
+	}
+
+	maxBytes := maxBodyBytes(options)
+	if r.ContentLength > maxBytes {
+		return nil, &ResponseError{url, ErrBodyTooLarge}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, &ResponseError{url, ErrBodyTooLarge}
+	}
+
+	err = decodeResponse(body, options.StrictResponse, response)
+	if err != nil {
+		return nil, &ResponseError{url, err}
+	}
+
+	return &cache.Entry{FetchedAt: time.Now(), SourceUrl: url, ETag: r.Header.Get("ETag"), Body: body}, nil
+}
+
+func checkContentType(contentType string, strict bool) error {
+	if contentType == "" {
+		if strict {
+			return fmt.Errorf("%w: none", ErrContentType)
+		}
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrContentType, contentType)
+	}
+
+	if mediaType == "application/json" || (!strict && lenientContentTypes[mediaType]) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrContentType, mediaType)
+}
+
+// DecodeResponse decodes and validates a saved response body the same way a fetched one is checked. Bodies over options.MaxBodyBytes are refused, and so are unknown fields, duplicate fields and trailing data with options.StrictResponse.
+func DecodeResponse(body []byte, options FetchOptions) (*parser.EnvServiceResponse, error) {
+	if int64(len(body)) > maxBodyBytes(options) {
+		return nil, ErrBodyTooLarge
+	}
+
+	response := new(parser.EnvServiceResponse)
+	err := decodeResponse(body, options.StrictResponse, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// decodeResponse only sets response if body is valid. In strict mode, unknown fields, duplicate fields and trailing data are rejected.
+func decodeResponse(body []byte, strict bool, response *parser.EnvServiceResponse) error {
+	var err error
+	decoded := new(parser.EnvServiceResponse)
+
+	if strict {
+		err = decodeStrict(body, decoded)
+	} else {
+		err = json.Unmarshal(body, decoded)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJson, err)
+	}
+
+	err = decoded.Validate()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	*response = *decoded
+	return nil
+}
+
+func decodeStrict(body []byte, v interface{}) error {
+	err := checkDuplicateFields(json.NewDecoder(bytes.NewReader(body)))
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err = dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after top-level value")
+	}
+
+	return nil
+}
+
+// checkDuplicateFields walks the next json value and fails on any object with a repeated field. Fields are compared case-insensitively, as encoding/json matches them.
+func checkDuplicateFields(dec *json.Decoder) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := t.(json.Delim)
+	if !ok {
+		return nil
+	}
+
+	switch delim {
+	case '{':
+		fields := map[string]bool{}
+		for dec.More() {
+			t, err = dec.Token()
+			if err != nil {
+				return err
+			}
+
+			field := strings.ToLower(t.(string))
+			if fields[field] {
+				return fmt.Errorf("duplicate field %q", t)
+			}
+			fields[field] = true
+
+			err = checkDuplicateFields(dec)
+			if err != nil {
+				return err
+			}
+		}
+	case '[':
+		for dec.More() {
+			err = checkDuplicateFields(dec)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// closing delim
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,126 @@
+package fetch
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+
+	"github.com/certifi/gocertifi"
+)
+
+const (
+	CAStrategyAuto    = "auto"
+	CAStrategySystem  = "system"
+	CAStrategyFile    = "file"
+	CAStrategyMozilla = "mozilla"
+)
+
+// loadRootCAs resolves the root CA pool once, when the client is built, so that nothing needs to be swapped out on a live client.
+//
+// The "auto" strategy uses system roots (plus CAFile if supplied), then CAFile alone if system roots can't be loaded, then the embedded Mozilla roots from gocertifi.
+func loadRootCAs(options FetchOptions) (*x509.CertPool, error) {
+	switch options.CAStrategy {
+	case "", CAStrategyAuto:
+		pool, err := systemRootCAs()
+		if err == nil {
+			if options.CAFile != "" {
+				err = appendCAFile(pool, options.CAFile)
+				if err != nil {
+					return nil, err
+				}
+				logRootCAsIfVerbose(options, "system and "+options.CAFile)
+			} else {
+				logRootCAsIfVerbose(options, "system")
+			}
+			return pool, nil
+		}
+
+		if options.VerboseOutput {
+			fmt.Fprintln(os.Stderr, "Error loading system root certificates:")
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		if options.CAFile != "" {
+			pool, err = caFileRootCAs(options.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			logRootCAsIfVerbose(options, options.CAFile)
+			return pool, nil
+		}
+
+		logRootCAsIfVerbose(options, "embedded Mozilla roots")
+		return gocertifi.CACerts()
+
+	case CAStrategySystem:
+		pool, err := systemRootCAs()
+		if err != nil {
+			return nil, err
+		}
+		logRootCAsIfVerbose(options, "system")
+		return pool, nil
+
+	case CAStrategyFile:
+		if options.CAFile == "" {
+			return nil, errors.New("the file CA strategy requires a CA file")
+		}
+		pool, err := caFileRootCAs(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		logRootCAsIfVerbose(options, options.CAFile)
+		return pool, nil
+
+	case CAStrategyMozilla:
+		logRootCAsIfVerbose(options, "embedded Mozilla roots")
+		return gocertifi.CACerts()
+
+	default:
+		return nil, errors.New("unknown CA strategy: " + options.CAStrategy)
+	}
+}
+
+func systemRootCAs() (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, err
+	}
+
+	// On darwin and windows the pool defers to the platform verifier and always looks empty
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" && runtime.GOOS != "ios" && len(pool.Subjects()) == 0 {
+		return nil, errors.New("x509: failed to load system roots")
+	}
+
+	return pool, nil
+}
+
+func caFileRootCAs(path string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	err := appendCAFile(pool, path)
+	if err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+func appendCAFile(pool *x509.CertPool, path string) error {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("no certificates found in CA file: " + path)
+	}
+
+	return nil
+}
+
+func logRootCAsIfVerbose(options FetchOptions, source string) {
+	if options.VerboseOutput {
+		fmt.Fprintf(os.Stderr, "Using root certificates from %s\n", source)
+	}
+}
@@ -0,0 +1,46 @@
+package fetch
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/envkey/envkey-fetch/trust"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// DefaultTrustPolicyFile is loaded when FetchOptions.TrustPolicyFile isn't set, if it exists.
+const DefaultTrustPolicyFile = "~/.envkey/trust_policy.json"
+
+// LoadTrustPolicy returns options.TrustPolicy if it's set. Otherwise it returns the policy for envkey in options.TrustPolicyFile, or in DefaultTrustPolicyFile if that exists. It's nil if there's no policy.
+func LoadTrustPolicy(envkey string, options FetchOptions) (*trust.Policy, error) {
+	if options.TrustPolicy != nil {
+		return options.TrustPolicy, nil
+	}
+
+	path := options.TrustPolicyFile
+	if path == "" {
+		path = DefaultTrustPolicyFile
+	}
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && options.TrustPolicyFile == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	policyFile, err := trust.ParsePolicyFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	envkeyParam, _ := envkeyIdAndHost(envkey)
+	return policyFile.For(envkeyParam), nil
+}
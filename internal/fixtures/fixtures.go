@@ -0,0 +1,187 @@
+// Package fixtures generates keys and validly signed env service responses for tests.
+package fixtures
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/envkey/envkey-fetch/parser"
+	"github.com/envkey/envkey-fetch/trust"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// small keys keep tests fast
+var config = &packet.Config{RSABits: 1024}
+
+// Org is an ENVKEY keypair plus a signer that's trusted by it directly.
+type Org struct {
+	Envkey   *openpgp.Entity
+	Signer   *openpgp.Entity
+	SignerId string
+}
+
+func NewOrg() (*Org, error) {
+	envkey, err := NewEntity("envkey")
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := NewEntity("signer")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Org{envkey, signer, "signer-id"}, nil
+}
+
+// SignerFingerprint is the hex encoded fingerprint of the signer's primary key, as crypto.Fingerprint returns it.
+func (org *Org) SignerFingerprint() string {
+	return hex.EncodeToString(org.Signer.PrimaryKey.Fingerprint)
+}
+
+// NewEntity generates a keypair that prefers SHA256, since openpgp can't otherwise pick a hash when encrypting to it.
+func NewEntity(name string) (*openpgp.Entity, error) {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, identity := range entity.Identities {
+		identity.SelfSignature.PreferredHash = []uint8{8} // SHA256
+		err = identity.SelfSignature.SignUserId(identity.UserId.Id, entity.PrimaryKey, entity.PrivateKey, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entity, nil
+}
+
+// Response returns the json body the env service would serve for env. The private key isn't passphrase protected, so any passphrase works.
+func (org *Org) Response(env map[string]string, allowCaching bool) ([]byte, error) {
+	privkey, err := ArmoredPrivkey(org.Envkey)
+	if err != nil {
+		return nil, err
+	}
+
+	pubkey, err := ArmoredPubkey(org.Envkey)
+	if err != nil {
+		return nil, err
+	}
+
+	signerPubkey, err := ArmoredPubkey(org.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedJson, err := json.Marshal(trust.TrustedKeyablesMap{
+		org.SignerId: trust.TrustedKeyable{PubkeyArmored: signerPubkey},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signedTrusted, err := ClearSign(trustedJson, org.Envkey)
+	if err != nil {
+		return nil, err
+	}
+
+	signerSignedTrusted, err := ClearSign(trustedJson, org.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	envJson, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedEnv, err := EncryptAndSign(envJson, org.Envkey, org.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(parser.EnvServiceResponse{
+		Env:                    encryptedEnv,
+		EncryptedPrivkey:       privkey,
+		PubkeyArmored:          pubkey,
+		SignedTrustedPubkeys:   signedTrusted,
+		SignedById:             org.SignerId,
+		SignedByPubkeyArmored:  signerPubkey,
+		SignedByTrustedPubkeys: signerSignedTrusted,
+		AllowCaching:           allowCaching,
+	})
+}
+
+func ArmoredPubkey(entity *openpgp.Entity) (string, error) {
+	buf := new(bytes.Buffer)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	err = entity.Serialize(w)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	return buf.String(), err
+}
+
+func ArmoredPrivkey(entity *openpgp.Entity) (string, error) {
+	buf := new(bytes.Buffer)
+	w, err := armor.Encode(buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	err = entity.SerializePrivate(w, config)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	return buf.String(), err
+}
+
+func ClearSign(msg []byte, signer *openpgp.Entity) (string, error) {
+	buf := new(bytes.Buffer)
+	w, err := clearsign.Encode(buf, signer.PrivateKey, config)
+	if err != nil {
+		return "", err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	return buf.String(), err
+}
+
+func EncryptAndSign(msg []byte, to, signer *openpgp.Entity) (string, error) {
+	buf := new(bytes.Buffer)
+	armorWriter, err := armor.Encode(buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var w io.WriteCloser
+	w, err = openpgp.Encrypt(armorWriter, openpgp.EntityList{to}, signer, nil, config)
+	if err != nil {
+		return "", err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = armorWriter.Close()
+	return buf.String(), err
+}
@@ -0,0 +1,31 @@
+// Package parallel runs independent checks concurrently.
+package parallel
+
+import "sync"
+
+// Errors runs fns concurrently and returns their errors in the order fns are listed.
+func Errors(fns ...func() error) []error {
+	errs := make([]error, len(fns))
+
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn func() error) {
+			defer wg.Done()
+			errs[i] = fn()
+		}(i, fn)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// First runs fns concurrently and returns the first error in the order fns are listed, so which error is returned doesn't depend on timing.
+func First(fns ...func() error) error {
+	for _, err := range Errors(fns...) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
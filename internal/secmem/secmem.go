@@ -0,0 +1,33 @@
+// Package secmem keeps secrets in byte buffers that are wiped once they're no longer needed.
+//
+// Go strings can't be wiped and the garbage collector may leave copies of anything behind, so this only narrows how long secrets stay in memory. It doesn't guarantee they're gone.
+package secmem
+
+import "runtime"
+
+// Zero overwrites b with zeros.
+func Zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
+
+// Copy copies s into a new buffer that free wipes. With lock set, the buffer is locked into memory where supported, so it can't be swapped to disk. If it can't be locked, an unlocked buffer is returned along with the error.
+func Copy(s string, lock bool) (buf []byte, free func(), err error) {
+	if lock && len(s) > 0 {
+		buf, free, err = alloc(len(s))
+		if err == nil {
+			copy(buf, s)
+			return buf, free, nil
+		}
+	}
+
+	buf = []byte(s)
+	return buf, func() { Zero(buf) }, err
+}
+
+// DisableCoreDumps stops the process from writing core dumps, or being attached to by debuggers run as the same user, where supported.
+func DisableCoreDumps() error {
+	return disableCoreDumps()
+}
@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package secmem
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// alloc maps pages of its own for the buffer rather than using the heap, so locking and unlocking them can't affect other allocations that share a page.
+func alloc(size int) ([]byte, func(), error) {
+	pageSize := unix.Getpagesize()
+	mapped, err := unix.Mmap(-1, 0, (size+pageSize-1)/pageSize*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't allocate locked memory: %w", err)
+	}
+
+	err = unix.Mlock(mapped)
+	if err != nil {
+		unix.Munmap(mapped)
+		return nil, nil, fmt.Errorf("couldn't lock memory (raise the locked memory limit with ulimit -l): %w", err)
+	}
+
+	free := func() {
+		Zero(mapped)
+		unix.Munlock(mapped)
+		unix.Munmap(mapped)
+	}
+	return mapped[:size:size], free, nil
+}
+
+// disableCoreDumps marks the process non-dumpable, which also stops core dumps and keeps /proc/self/mem and ptrace away from other processes of the same user.
+func disableCoreDumps() error {
+	err := unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("couldn't disable core dumps: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package secmem
+
+import "errors"
+
+func alloc(size int) ([]byte, func(), error) {
+	return nil, nil, errors.New("locking memory isn't supported on this platform")
+}
+
+func disableCoreDumps() error {
+	return nil
+}
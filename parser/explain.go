@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"errors"
+
+	"github.com/envkey/envkey-fetch/crypto"
+	"github.com/envkey/envkey-fetch/trust"
+)
+
+// Names of the steps ParseVerified goes through
+const (
+	StepValidate         = "validate"
+	StepParseKeys        = "parse keys"
+	StepParseTrustChain  = "parse trust chain"
+	StepVerifyTrusted    = "verify signers trusted"
+	StepDecryptAndVerify = "decrypt and verify"
+)
+
+// Explanation describes each step of verifying a response, and how each signer was found trusted or where that failed. It never includes env values.
+type Explanation struct {
+	Steps    []*Step              `json:"steps"`
+	Signers  []*trust.SignerTrust `json:"signers"`
+	Verified bool                 `json:"verified"`
+}
+
+// Step is a step of ParseVerified. Error is set if it failed, in which case later steps aren't run.
+type Step struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// Explain goes through the same steps as ParseVerifiedWithPolicy, recording the result of each instead of stopping at the first error.
+func (response *EnvServiceResponse) Explain(pw []byte, policy *trust.Policy) *Explanation {
+	explanation := &Explanation{Steps: []*Step{}, Signers: []*trust.SignerTrust{}}
+
+	if !explanation.step(StepValidate, response.validate()) {
+		return explanation
+	}
+
+	responseWithKeys, err := response.parseKeys(pw)
+	if !explanation.step(StepParseKeys, err) {
+		return explanation
+	}
+	defer crypto.WipePrivkey(responseWithKeys.DecryptedPrivkey)
+
+	responseWithTrustChain, err := responseWithKeys.parseTrustChain(policy)
+	if !explanation.step(StepParseTrustChain, err) {
+		return explanation
+	}
+
+	signers := []*trust.Signer{responseWithTrustChain.Signer}
+	if responseWithTrustChain.hasInheritanceOverrides() {
+		signers = append(signers, responseWithTrustChain.InheritanceOverridesSigner)
+	}
+
+	err = nil
+	for _, signer := range signers {
+		signerTrust := responseWithTrustChain.TrustedKeyablesChain.Explain(signer)
+		explanation.Signers = append(explanation.Signers, signerTrust)
+		if err == nil && signerTrust.Error != "" {
+			err = errors.New(signerTrust.Error)
+		}
+	}
+	if !explanation.step(StepVerifyTrusted, err) {
+		return explanation
+	}
+
+	_, err = responseWithTrustChain.decryptAndVerify()
+	explanation.Verified = explanation.step(StepDecryptAndVerify, err)
+	return explanation
+}
+
+func (explanation *Explanation) step(name string, err error) bool {
+	step := &Step{Name: name}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	explanation.Steps = append(explanation.Steps, step)
+	return err == nil
+}
@@ -5,9 +5,9 @@ import (
 	"errors"
 
 	"github.com/envkey/envkey-fetch/crypto"
+	"github.com/envkey/envkey-fetch/internal/parallel"
+	"github.com/envkey/envkey-fetch/internal/secmem"
 	"github.com/envkey/envkey-fetch/trust"
-
-	"golang.org/x/crypto/openpgp"
 )
 
 type EnvServiceResponse struct {
@@ -26,6 +26,10 @@ type EnvServiceResponse struct {
 }
 
 func (response *EnvServiceResponse) validate() error {
+	if response == nil {
+		return errors.New("No response.")
+	}
+
 	valid := response.Env != "" &&
 		response.EncryptedPrivkey != "" &&
 		response.PubkeyArmored != "" &&
@@ -41,6 +45,11 @@ func (response *EnvServiceResponse) validate() error {
 	return response.validateInheritanceOverrides()
 }
 
+// Validate checks that required fields are present, without doing any decryption or verification.
+func (response *EnvServiceResponse) Validate() error {
+	return response.validate()
+}
+
 func (response *EnvServiceResponse) hasInheritanceOverrides() bool {
 	return response.InheritanceOverrides != "" &&
 		response.InheritanceOverridesSignedById != "" &&
@@ -61,7 +70,32 @@ func (response *EnvServiceResponse) validateInheritanceOverrides() error {
 	return nil
 }
 
-func (response *EnvServiceResponse) Parse(pw string) (string, error) {
+// Parse decrypts and verifies the env, returning it as json. pw is only read, so the caller can wipe it afterwards.
+func (response *EnvServiceResponse) Parse(pw []byte) (string, error) {
+	verifiedEnv, err := response.ParseVerified(pw)
+	if err != nil {
+		return "", err
+	}
+	return verifiedEnv.Json, nil
+}
+
+// VerifiedEnv is the decrypted env json along with the identities of the keys that signed it, and the fingerprints of the creator trusted root keys by id.
+type VerifiedEnv struct {
+	Json                                  string
+	SignerId                              string
+	SignerFingerprint                     string
+	InheritanceOverridesSignerId          string
+	InheritanceOverridesSignerFingerprint string
+	RootFingerprints                      map[string]string
+}
+
+func (response *EnvServiceResponse) ParseVerified(pw []byte) (*VerifiedEnv, error) {
+	return response.ParseVerifiedWithPolicy(pw, nil)
+}
+
+// ParseVerifiedWithPolicy is ParseVerified with signers and roots also checked against policy, before anything is decrypted. A nil policy doesn't restrict anything.
+// The decrypted private key is wiped before it returns.
+func (response *EnvServiceResponse) ParseVerifiedWithPolicy(pw []byte, policy *trust.Policy) (*VerifiedEnv, error) {
 	var err error
 	var responseWithKeys *ResponseWithKeys
 	var responseWithTrustChain *ResponseWithTrustChain
@@ -69,56 +103,90 @@ func (response *EnvServiceResponse) Parse(pw string) (string, error) {
 
 	err = response.validate()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	responseWithKeys, err = response.parseKeys(pw)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer crypto.WipePrivkey(responseWithKeys.DecryptedPrivkey)
 
-	responseWithTrustChain, err = responseWithKeys.parseTrustChain()
+	responseWithTrustChain, err = responseWithKeys.parseTrustChain(policy)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	decryptedVerified, err = responseWithTrustChain.decryptAndVerify()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return decryptedVerified.toJson()
-}
-
-func (response *EnvServiceResponse) parseKeys(pw string) (*ResponseWithKeys, error) {
-	var err error
-	var decryptedPrivkey, verifiedPubkey, signedByPubkey, inheritanceOverridesSignedByPubkey openpgp.EntityList
-
-	decryptedPrivkey, err = crypto.ReadPrivkey([]byte(response.EncryptedPrivkey), []byte(pw))
+	json, err := decryptedVerified.toJson()
 	if err != nil {
 		return nil, err
 	}
 
-	verifiedPubkey, err = crypto.ReadArmoredKey([]byte(response.PubkeyArmored))
+	rootFingerprints, err := responseWithTrustChain.TrustedKeyablesChain.CreatorTrusted.Fingerprints()
 	if err != nil {
 		return nil, err
 	}
 
-	err = crypto.VerifyPubkeyWithPrivkey(verifiedPubkey, decryptedPrivkey)
-	if err != nil {
-		return nil, err
+	verifiedEnv := VerifiedEnv{
+		Json:              json,
+		SignerId:          response.SignedById,
+		SignerFingerprint: crypto.Fingerprint(responseWithKeys.SignedByPubkey),
+		RootFingerprints:  rootFingerprints,
+	}
+
+	if response.hasInheritanceOverrides() {
+		verifiedEnv.InheritanceOverridesSignerId = response.InheritanceOverridesSignedById
+		verifiedEnv.InheritanceOverridesSignerFingerprint = crypto.Fingerprint(responseWithKeys.InheritanceOverridesSignedByPubkey)
 	}
 
-	signedByPubkey, err = crypto.ReadArmoredKey([]byte(response.SignedByPubkeyArmored))
+	return &verifiedEnv, nil
+}
+
+func (response *EnvServiceResponse) parseKeys(pw []byte) (*ResponseWithKeys, error) {
+	var err error
+	var decryptedPrivkey, verifiedPubkey, signedByPubkey, inheritanceOverridesSignedByPubkey crypto.Keyring
+
+	// the private key may be decrypted even if reading a public key fails, so it's wiped on every error
+	parsed := false
+	defer func() {
+		if !parsed {
+			crypto.WipePrivkey(decryptedPrivkey)
+		}
+	}()
+
+	// decrypting the private key is slowest, so the public keys are read alongside it
+	err = parallel.First(
+		func() (err error) {
+			decryptedPrivkey, err = crypto.ReadPrivkey([]byte(response.EncryptedPrivkey), pw)
+			return err
+		},
+		func() (err error) {
+			verifiedPubkey, err = crypto.ReadArmoredKey([]byte(response.PubkeyArmored))
+			return err
+		},
+		func() (err error) {
+			signedByPubkey, err = crypto.ReadArmoredKey([]byte(response.SignedByPubkeyArmored))
+			return err
+		},
+		func() (err error) {
+			if response.hasInheritanceOverrides() {
+				inheritanceOverridesSignedByPubkey, err = crypto.ReadArmoredKey([]byte(response.InheritanceOverridesSignedByPubkeyArmored))
+			}
+			return err
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.hasInheritanceOverrides() {
-		inheritanceOverridesSignedByPubkey, err = crypto.ReadArmoredKey([]byte(response.InheritanceOverridesSignedByPubkeyArmored))
-		if err != nil {
-			return nil, err
-		}
+	err = crypto.VerifyPubkeyWithPrivkey(verifiedPubkey, decryptedPrivkey)
+	if err != nil {
+		return nil, err
 	}
 
 	responseWithKeys := ResponseWithKeys{
@@ -131,12 +199,13 @@ func (response *EnvServiceResponse) parseKeys(pw string) (*ResponseWithKeys, err
 		InheritanceSignerKeyring:           append(decryptedPrivkey, inheritanceOverridesSignedByPubkey...),
 	}
 
+	parsed = true
 	return &responseWithKeys, nil
 }
 
 type ResponseWithKeys struct {
 	RawResponse                                                                                                                   *EnvServiceResponse
-	DecryptedPrivkey, VerifiedPubkey, SignerKeyring, InheritanceSignerKeyring, SignedByPubkey, InheritanceOverridesSignedByPubkey openpgp.EntityList
+	DecryptedPrivkey, VerifiedPubkey, SignerKeyring, InheritanceSignerKeyring, SignedByPubkey, InheritanceOverridesSignedByPubkey crypto.Keyring
 }
 
 func (response *ResponseWithKeys) hasInheritanceOverrides() bool {
@@ -145,10 +214,10 @@ func (response *ResponseWithKeys) hasInheritanceOverrides() bool {
 
 func (response *ResponseWithKeys) signer() *trust.Signer {
 	return &trust.Signer{
-		response.RawResponse.SignedById,
-		response.RawResponse.SignedByPubkeyArmored,
-		response.SignedByPubkey,
-		false,
+		Id:                  response.RawResponse.SignedById,
+		PubkeyArmored:       response.RawResponse.SignedByPubkeyArmored,
+		Pubkey:              response.SignedByPubkey,
+		IsInheritanceSigner: false,
 	}
 }
 
@@ -157,10 +226,10 @@ func (response *ResponseWithKeys) inheritanceOverridesSigner() *trust.Signer {
 		return nil
 	}
 	return &trust.Signer{
-		response.RawResponse.InheritanceOverridesSignedById,
-		response.RawResponse.InheritanceOverridesSignedByPubkeyArmored,
-		response.InheritanceOverridesSignedByPubkey,
-		true,
+		Id:                  response.RawResponse.InheritanceOverridesSignedById,
+		PubkeyArmored:       response.RawResponse.InheritanceOverridesSignedByPubkeyArmored,
+		Pubkey:              response.InheritanceOverridesSignedByPubkey,
+		IsInheritanceSigner: true,
 	}
 }
 
@@ -168,41 +237,49 @@ func (response *ResponseWithKeys) trustedKeyablesChain() (*trust.TrustedKeyables
 	var err error
 	var creatorTrusted, signerTrusted, inheritanceOverridesTrusted trust.TrustedKeyablesMap
 
-	creatorTrusted, err = parseTrustedKeys(response.RawResponse.SignedTrustedPubkeys, response.VerifiedPubkey)
-	if err != nil {
-		return nil, err
-	}
-
-	signerTrusted, err = parseTrustedKeys(response.RawResponse.SignedByTrustedPubkeys, response.SignedByPubkey)
+	err = parallel.First(
+		func() (err error) {
+			creatorTrusted, err = parseTrustedKeys(response.RawResponse.SignedTrustedPubkeys, response.VerifiedPubkey)
+			return err
+		},
+		func() (err error) {
+			signerTrusted, err = parseTrustedKeys(response.RawResponse.SignedByTrustedPubkeys, response.SignedByPubkey)
+			return err
+		},
+		func() (err error) {
+			if response.hasInheritanceOverrides() {
+				inheritanceOverridesTrusted, err = parseTrustedKeys(
+					response.RawResponse.InheritanceOverridesSignedByTrustedPubkeys,
+					response.InheritanceOverridesSignedByPubkey,
+				)
+			}
+			return err
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.hasInheritanceOverrides() {
-		inheritanceOverridesTrusted, err = parseTrustedKeys(
-			response.RawResponse.InheritanceOverridesSignedByTrustedPubkeys,
-			response.InheritanceOverridesSignedByPubkey,
-		)
-		if err != nil {
-			return nil, err
-		}
+	trustedChain := trust.TrustedKeyablesChain{
+		CreatorTrusted:                    creatorTrusted,
+		SignerTrusted:                     signerTrusted,
+		InheritanceOverridesSignerTrusted: inheritanceOverridesTrusted,
 	}
 
-	trustedChain := trust.TrustedKeyablesChain{creatorTrusted, signerTrusted, inheritanceOverridesTrusted}
-
 	return &trustedChain, nil
 }
 
-func (response *ResponseWithKeys) parseTrustChain() (*ResponseWithTrustChain, error) {
+func (response *ResponseWithKeys) parseTrustChain(policy *trust.Policy) (*ResponseWithTrustChain, error) {
 	trustedKeyablesChain, err := response.trustedKeyablesChain()
 	if err != nil {
 		return nil, err
 	}
+	trustedKeyablesChain.Policy = policy
 
 	responseWithTrustChain := ResponseWithTrustChain{
-		ResponseWithKeys:     response,
-		TrustedKeyablesChain: trustedKeyablesChain,
-		Signer:               response.signer(),
+		ResponseWithKeys:           response,
+		TrustedKeyablesChain:       trustedKeyablesChain,
+		Signer:                     response.signer(),
 		InheritanceOverridesSigner: response.inheritanceOverridesSigner(),
 	}
 
@@ -232,45 +309,59 @@ func (response *ResponseWithTrustChain) verifyTrusted(signer *trust.Signer) erro
 	return nil
 }
 
+// decryptAndVerify checks that the signers are trusted, then decrypts the env and inheritance overrides, so nothing is decrypted from an untrusted signer or one the policy rejects. Each pair of independent steps runs concurrently, with errors reported in the same order as if they ran one by one. The decrypted bytes are wiped once they've been copied into the response.
 func (response *ResponseWithTrustChain) decryptAndVerify() (*DecryptedVerifiedResponse, error) {
-	var err error
-
-	// verify signer trusted
-	err = response.verifyTrusted(response.Signer)
+	var decryptedEnvBytes, decryptedInheritanceBytes []byte
+	defer func() {
+		secmem.Zero(decryptedEnvBytes)
+		secmem.Zero(decryptedInheritanceBytes)
+	}()
+
+	err := parallel.First(
+		// verify signer trusted
+		func() error {
+			return response.verifyTrusted(response.Signer)
+		},
+		// verify inheritance overrides signer trusted
+		func() error {
+			if !response.hasInheritanceOverrides() {
+				return nil
+			}
+			return response.verifyTrusted(response.InheritanceOverridesSigner)
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	// verify inheritance overrides signer trusted
-	if response.hasInheritanceOverrides() {
-		err = response.verifyTrusted(response.InheritanceOverridesSigner)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	decryptedVerifiedResponse := new(DecryptedVerifiedResponse)
-
-	// decrypt env
-	var decryptedEnvBytes []byte
-	decryptedEnvBytes, err = crypto.DecryptAndVerify(
-		[]byte(response.ResponseWithKeys.RawResponse.Env),
-		response.ResponseWithKeys.SignerKeyring,
+	err = parallel.First(
+		// decrypt env
+		func() (err error) {
+			decryptedEnvBytes, err = crypto.DecryptAndVerify(
+				[]byte(response.ResponseWithKeys.RawResponse.Env),
+				response.ResponseWithKeys.SignerKeyring,
+			)
+			return err
+		},
+		// decrypt inheritance overrides
+		func() (err error) {
+			if !response.hasInheritanceOverrides() {
+				return nil
+			}
+			decryptedInheritanceBytes, err = crypto.DecryptAndVerify(
+				[]byte(response.ResponseWithKeys.RawResponse.InheritanceOverrides),
+				response.ResponseWithKeys.InheritanceSignerKeyring,
+			)
+			return err
+		},
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.hasInheritanceOverrides() {
-		var decryptedInheritanceBytes []byte
-		decryptedInheritanceBytes, err = crypto.DecryptAndVerify(
-			[]byte(response.ResponseWithKeys.RawResponse.InheritanceOverrides),
-			response.ResponseWithKeys.InheritanceSignerKeyring,
-		)
-		if err != nil {
-			return nil, err
-		}
+	decryptedVerifiedResponse := new(DecryptedVerifiedResponse)
 
+	if response.hasInheritanceOverrides() {
 		var env, inheritanceOverrides map[string]interface{}
 		err = json.Unmarshal(decryptedEnvBytes, &env)
 		if err != nil {
@@ -319,7 +410,7 @@ func (response *DecryptedVerifiedResponse) toMap() map[string]interface{} {
 	return env
 }
 
-func parseTrustedKeys(rawTrusted string, signerPubkey openpgp.EntityList) (trust.TrustedKeyablesMap, error) {
+func parseTrustedKeys(rawTrusted string, signerPubkey crypto.Keyring) (trust.TrustedKeyablesMap, error) {
 	var err error
 	var verified []byte
 
@@ -0,0 +1,39 @@
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/envkey/envkey-fetch/crypto"
+	"github.com/envkey/envkey-fetch/parser"
+)
+
+func BenchmarkParse(b *testing.B) {
+	benchmarks := []struct {
+		name     string
+		response *parser.EnvServiceResponse
+	}{
+		{"env", &response},
+		{"with inheritance", &responseWithInheritance},
+	}
+
+	for _, benchmark := range benchmarks {
+		name, response := benchmark.name, benchmark.response
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := response.Parse(passphrase); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		// the first fetch in a process, before any keys are memoized
+		b.Run(name+" uncached", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				crypto.ClearKeyCache()
+				if _, err := response.Parse(passphrase); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
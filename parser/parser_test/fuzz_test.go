@@ -0,0 +1,32 @@
+package parser_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/parser"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNil(t *testing.T) {
+	var response *parser.EnvServiceResponse
+	_, err := response.Parse(passphrase)
+	assert.NotNil(t, err, "Should return an error.")
+	assert.False(t, response.Explain(passphrase, nil).Verified, "Should not be verified.")
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []parser.EnvServiceResponse{response, responseWithInheritance, {}} {
+		body, _ := json.Marshal(seed)
+		f.Add(body, passphrase)
+	}
+	f.Fuzz(func(t *testing.T, body []byte, pw []byte) {
+		var response parser.EnvServiceResponse
+		if json.Unmarshal(body, &response) != nil {
+			return
+		}
+		response.Parse(pw)
+		response.Explain(pw, nil)
+	})
+}
@@ -0,0 +1,72 @@
+package trust
+
+import (
+	"github.com/envkey/envkey-fetch/crypto"
+)
+
+// Where a signer's key was listed as trusted
+const (
+	ListedInCreatorTrusted                    = "creator_trusted"
+	ListedInSignerTrusted                     = "signer_trusted"
+	ListedInInheritanceOverridesSignerTrusted = "inheritance_overrides_signer_trusted"
+)
+
+// Link is a key on the path from a signer back to a CreatorTrusted root. Error is set on the link where verification stopped, for example if its invite key wasn't signed by the key that invited it.
+type Link struct {
+	Id          string `json:"id"`
+	Fingerprint string `json:"fingerprint"`
+	InvitedById string `json:"invited_by_id,omitempty"`
+	Root        bool   `json:"root"`
+	Error       string `json:"error,omitempty"`
+}
+
+// SignerTrust describes how a signer's key was found trusted, or where that failed. TrustedFingerprint is the fingerprint listed for the signer's id, which must match Fingerprint.
+type SignerTrust struct {
+	Id                  string  `json:"id"`
+	IsInheritanceSigner bool    `json:"is_inheritance_signer"`
+	Fingerprint         string  `json:"fingerprint"`
+	ListedIn            string  `json:"listed_in,omitempty"`
+	TrustedFingerprint  string  `json:"trusted_fingerprint,omitempty"`
+	Path                []*Link `json:"path"`
+	Trusted             bool    `json:"trusted"`
+	Error               string  `json:"error,omitempty"`
+}
+
+func newSignerTrust(signer *Signer) *SignerTrust {
+	if signer == nil {
+		return &SignerTrust{Path: []*Link{}}
+	}
+	return &SignerTrust{
+		Id:                  signer.Id,
+		IsInheritanceSigner: signer.IsInheritanceSigner,
+		Fingerprint:         crypto.Fingerprint(signer.Pubkey),
+		Path:                []*Link{},
+	}
+}
+
+// lookup records where the signer's id is listed, if it's in trustedKeyables.
+func (explanation *SignerTrust) lookup(trustedKeyables TrustedKeyablesMap, listedIn string) {
+	listed, ok := trustedKeyables[explanation.Id]
+	if !ok {
+		return
+	}
+	explanation.ListedIn = listedIn
+	explanation.TrustedFingerprint = armoredFingerprint(listed.PubkeyArmored)
+}
+
+func newLink(id string, keyable *TrustedKeyable) *Link {
+	return &Link{
+		Id:          id,
+		Fingerprint: armoredFingerprint(keyable.PubkeyArmored),
+		InvitedById: keyable.InvitedById,
+	}
+}
+
+// armoredFingerprint is "" for a key that can't be read.
+func armoredFingerprint(pubkeyArmored string) string {
+	pubkey, err := crypto.ReadArmoredKey([]byte(pubkeyArmored))
+	if err != nil {
+		return ""
+	}
+	return crypto.Fingerprint(pubkey)
+}
@@ -0,0 +1,192 @@
+package trust
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/envkey/envkey-fetch/crypto"
+)
+
+// Kinds of entries in KnownSigners
+const (
+	KnownRoot   = "root"
+	KnownSigner = "signer"
+)
+
+// Known holds the fingerprints of root and signer keys seen for an ENVKEY, by key id.
+type Known struct {
+	Roots   map[string]string
+	Signers map[string]string
+}
+
+// KnownSigners holds what's been seen for each ENVKEY, keyed by a name that identifies the ENVKEY.
+// It's stored as lines of "name root|signer id fingerprint", like ssh's known_hosts. Blank lines and lines starting with # are ignored.
+type KnownSigners map[string]*Known
+
+// Change is a difference between what's known for an ENVKEY and what's been seen since.
+type Change struct {
+	Kind  string
+	Id    string
+	Known string
+	Seen  string
+}
+
+func (change *Change) String() string {
+	switch {
+	case change.Known == "":
+		return fmt.Sprintf("new %s %s with fingerprint %s", change.Kind, change.Id, change.Seen)
+	case change.Seen == "":
+		return fmt.Sprintf("%s %s with fingerprint %s is gone", change.Kind, change.Id, change.Known)
+	default:
+		return fmt.Sprintf("%s %s fingerprint changed from %s to %s", change.Kind, change.Id, change.Known, change.Seen)
+	}
+}
+
+func NewKnown() *Known {
+	return &Known{Roots: map[string]string{}, Signers: map[string]string{}}
+}
+
+// Fingerprints returns the fingerprint of each key, by id.
+func (trustedKeyables TrustedKeyablesMap) Fingerprints() (map[string]string, error) {
+	fingerprints := map[string]string{}
+	for id, keyable := range trustedKeyables {
+		pubkey, err := crypto.ReadArmoredKey([]byte(keyable.PubkeyArmored))
+		if err != nil {
+			return nil, err
+		}
+		fingerprints[id] = crypto.Fingerprint(pubkey)
+	}
+	return fingerprints, nil
+}
+
+func ParseKnownSigners(data []byte) (KnownSigners, error) {
+	knownSigners := KnownSigners{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 4 || (fields[1] != KnownRoot && fields[1] != KnownSigner) {
+			return nil, fmt.Errorf("Invalid known signers line %d.", n)
+		}
+
+		known, ok := knownSigners[fields[0]]
+		if !ok {
+			known = NewKnown()
+			knownSigners[fields[0]] = known
+		}
+		if fields[1] == KnownRoot {
+			known.Roots[fields[2]] = normalizeFingerprint(fields[3])
+		} else {
+			known.Signers[fields[2]] = normalizeFingerprint(fields[3])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("Invalid known signers: " + err.Error())
+	}
+	return knownSigners, nil
+}
+
+// Format returns knownSigners in the format ParseKnownSigners reads, sorted so that unchanged entries stay in place.
+func (knownSigners KnownSigners) Format() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Root and signer key fingerprints seen for each ENVKEY. Use `envkey-fetch trust accept` to approve changes.\n")
+
+	names := []string{}
+	for name := range knownSigners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		known := knownSigners[name]
+		for _, id := range sortedIds(known.Roots) {
+			fmt.Fprintf(&buf, "%s %s %s %s\n", name, KnownRoot, id, known.Roots[id])
+		}
+		for _, id := range sortedIds(known.Signers) {
+			fmt.Fprintf(&buf, "%s %s %s %s\n", name, KnownSigner, id, known.Signers[id])
+		}
+	}
+	return buf.Bytes()
+}
+
+// Check compares seen with what's known. Roots that are new or have changed fingerprints and signers whose fingerprints have changed are alerts. Other changes, like a new signer or a root that's gone, are only returned as updates.
+func (known *Known) Check(seen *Known) ([]*Change, []*Change) {
+	alerts, updates := []*Change{}, []*Change{}
+
+	for _, change := range diffFingerprints(KnownRoot, known.Roots, seen.Roots) {
+		if change.Seen != "" {
+			alerts = append(alerts, change)
+		} else {
+			updates = append(updates, change)
+		}
+	}
+
+	for _, change := range diffFingerprints(KnownSigner, known.Signers, seen.Signers) {
+		if change.Known != "" && change.Seen != "" {
+			alerts = append(alerts, change)
+		} else if change.Seen != "" {
+			updates = append(updates, change)
+		}
+	}
+
+	return alerts, updates
+}
+
+// Merge adds what's been seen, keeping roots that are gone. Signers are only ever added, since each response only has one or two.
+func (known *Known) Merge(seen *Known) {
+	for id, fingerprint := range seen.Roots {
+		known.Roots[id] = normalizeFingerprint(fingerprint)
+	}
+	for id, fingerprint := range seen.Signers {
+		known.Signers[id] = normalizeFingerprint(fingerprint)
+	}
+}
+
+// Accept replaces the known roots with those seen and merges in the signers seen, returning what changed.
+func (known *Known) Accept(seen *Known) []*Change {
+	changes := diffFingerprints(KnownRoot, known.Roots, seen.Roots)
+	for _, change := range diffFingerprints(KnownSigner, known.Signers, seen.Signers) {
+		if change.Seen != "" {
+			changes = append(changes, change)
+		}
+	}
+
+	known.Roots = map[string]string{}
+	known.Merge(seen)
+	return changes
+}
+
+func diffFingerprints(kind string, known, seen map[string]string) []*Change {
+	changes := []*Change{}
+	for _, id := range sortedIds(seen) {
+		fingerprint := normalizeFingerprint(seen[id])
+		if known[id] != fingerprint {
+			changes = append(changes, &Change{Kind: kind, Id: id, Known: known[id], Seen: fingerprint})
+		}
+	}
+	for _, id := range sortedIds(known) {
+		if _, ok := seen[id]; !ok {
+			changes = append(changes, &Change{Kind: kind, Id: id, Known: known[id]})
+		}
+	}
+	return changes
+}
+
+func sortedIds(fingerprints map[string]string) []string {
+	ids := []string{}
+	for id := range fingerprints {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,121 @@
+package trust
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrPolicy = errors.New("rejected by trust policy")
+
+// Policy restricts which keys are trusted, beyond what a response's signed trusted keys allow. Empty lists don't restrict anything. Fingerprints are hex, and spaces, colons and case are ignored.
+type Policy struct {
+	// the root a signer's trust chain ends at must have one of these fingerprints
+	RootFingerprints []string `json:"root_fingerprints,omitempty"`
+
+	// if either is set, signers must match one of them
+	AllowSignerIds          []string `json:"allow_signer_ids,omitempty"`
+	AllowSignerFingerprints []string `json:"allow_signer_fingerprints,omitempty"`
+
+	// signers matching either are rejected, e.g. revoked members
+	DenySignerIds          []string `json:"deny_signer_ids,omitempty"`
+	DenySignerFingerprints []string `json:"deny_signer_fingerprints,omitempty"`
+}
+
+// PolicyFile holds a default policy and policies for specific ENVKEYs, keyed by the ENVKEY's identifier (the part before the first "-"). An ENVKEY's own policy replaces the default rather than adding to it.
+type PolicyFile struct {
+	Default *Policy            `json:"default,omitempty"`
+	Envkeys map[string]*Policy `json:"envkeys,omitempty"`
+}
+
+func ParsePolicyFile(data []byte) (*PolicyFile, error) {
+	var policyFile PolicyFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&policyFile)
+	if err != nil {
+		return nil, errors.New("Invalid trust policy: " + err.Error())
+	}
+	return &policyFile, nil
+}
+
+// For returns the policy for envkeyParam, which is nil if there isn't one.
+func (policyFile *PolicyFile) For(envkeyParam string) *Policy {
+	if policy, ok := policyFile.Envkeys[envkeyParam]; ok {
+		return policy
+	}
+	return policyFile.Default
+}
+
+// CheckSigner rejects a signer that's denied, or not allowed when there's an allow list. A nil policy allows any signer.
+func (policy *Policy) CheckSigner(id, fingerprint string) error {
+	if policy == nil {
+		return nil
+	}
+
+	if contains(policy.DenySignerIds, id) || containsFingerprint(policy.DenySignerFingerprints, fingerprint) {
+		return policyError("signer " + id + " is denied")
+	}
+
+	hasAllowList := len(policy.AllowSignerIds) > 0 || len(policy.AllowSignerFingerprints) > 0
+	if hasAllowList && !contains(policy.AllowSignerIds, id) && !containsFingerprint(policy.AllowSignerFingerprints, fingerprint) {
+		return policyError("signer " + id + " is not allowed")
+	}
+
+	return nil
+}
+
+// CheckRoot rejects a root key that isn't pinned, when roots are pinned. A nil policy allows any root.
+func (policy *Policy) CheckRoot(id, fingerprint string) error {
+	if policy == nil || len(policy.RootFingerprints) == 0 {
+		return nil
+	}
+
+	if !containsFingerprint(policy.RootFingerprints, fingerprint) {
+		return policyError("root " + id + " with fingerprint " + fingerprint + " is not pinned")
+	}
+	return nil
+}
+
+func policyError(reason string) error {
+	return &PolicyError{Reason: reason}
+}
+
+// PolicyError is returned when a Policy rejects a key. It matches ErrPolicy.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "Rejected by trust policy: " + e.Reason + "."
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrPolicy
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFingerprint(list []string, fingerprint string) bool {
+	if fingerprint == "" {
+		return false
+	}
+	for _, item := range list {
+		if normalizeFingerprint(item) == normalizeFingerprint(fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", ":", "").Replace(fingerprint))
+}
@@ -4,14 +4,15 @@ import (
 	"errors"
 
 	"github.com/envkey/envkey-fetch/crypto"
-
-	"golang.org/x/crypto/openpgp"
+	"github.com/envkey/envkey-fetch/internal/parallel"
 )
 
+var errNoSigner = errors.New("No signer.")
+
 type Signer struct {
 	Id                  string
 	PubkeyArmored       string
-	Pubkey              openpgp.EntityList
+	Pubkey              crypto.Keyring
 	IsInheritanceSigner bool
 }
 
@@ -30,6 +31,10 @@ type TrustedKeyable struct {
 }
 
 func (keyable *TrustedKeyable) VerifyInviter(inviterKeyable *TrustedKeyable) error {
+	if inviterKeyable == nil {
+		return errors.New("No inviter.")
+	}
+
 	// Verify signed key signature
 	pubkeyArmored := keyable.PubkeyArmored
 	invitePubkeyArmored := keyable.InvitePubkeyArmored
@@ -47,13 +52,16 @@ func (keyable *TrustedKeyable) VerifyInviter(inviterKeyable *TrustedKeyable) err
 type TrustedKeyablesMap map[string]TrustedKeyable
 
 func (trustedKeyables TrustedKeyablesMap) SignerTrustedKeyable(signer *Signer) (*TrustedKeyable, error) {
+	if signer == nil {
+		return nil, errNoSigner
+	}
 	if trusted, ok := trustedKeyables[signer.Id]; ok {
 		trustedPubkey, err := crypto.ReadArmoredKey([]byte(trusted.PubkeyArmored))
 		if err != nil {
 			return nil, err
 		}
 
-		if trustedPubkey[0].PrimaryKey.Fingerprint == signer.Pubkey[0].PrimaryKey.Fingerprint {
+		if crypto.Fingerprint(trustedPubkey) == crypto.Fingerprint(signer.Pubkey) {
 			return &trusted, nil
 		} else {
 			return nil, errors.New("Signer pubkey fingerprint does not match trusted pubkey fingerprint.")
@@ -64,57 +72,88 @@ func (trustedKeyables TrustedKeyablesMap) SignerTrustedKeyable(signer *Signer) (
 }
 
 func (trustedKeyables TrustedKeyablesMap) TrustedRoot(keyable *TrustedKeyable, creatorTrusted TrustedKeyablesMap) ([]*TrustedKeyable, error) {
-	var trustedRoot *TrustedKeyable
-	var newlyVerified []*TrustedKeyable
-	var ok bool
-	currentKeyable := keyable
+	newlyVerified, _, err := trustedKeyables.trustedRoot("", keyable, creatorTrusted)
+	return newlyVerified, err
+}
+
+// trustedRoot is TrustedRoot, also returning the path it followed from keyable (listed under id) for Explain.
+// The invitations are followed up to a creator trusted root first, then each one is verified concurrently. The first failure along the path is reported, as if they were verified in order.
+func (trustedKeyables TrustedKeyablesMap) trustedRoot(id string, keyable *TrustedKeyable, creatorTrusted TrustedKeyablesMap) ([]*TrustedKeyable, []*Link, error) {
+	if keyable == nil {
+		return nil, []*Link{}, errors.New("No trusted keyable.")
+	}
+
+	// keyables[i] was invited by keyables[i+1]
+	keyables := []*TrustedKeyable{keyable}
+	path := []*Link{newLink(id, keyable)}
 	checked := make(map[string]bool)
+	var pathErr error
+
+	for {
+		currentKeyable := keyables[len(keyables)-1]
 
-	for trustedRoot == nil {
 		if currentKeyable.InvitedById == "" {
-			return nil, errors.New("No signing id.")
+			pathErr = errors.New("No signing id.")
+			break
 		}
 
-		if _, ok = checked[currentKeyable.InvitedById]; ok {
-			return nil, errors.New("Already checked signing id: " + currentKeyable.InvitedById)
+		if _, ok := checked[currentKeyable.InvitedById]; ok {
+			pathErr = errors.New("Already checked signing id: " + currentKeyable.InvitedById)
+			break
 		}
+		checked[currentKeyable.InvitedById] = true
 
-		var inviterKeyable TrustedKeyable
-		inviterKeyable, ok = creatorTrusted[currentKeyable.InvitedById]
-		if ok {
-			trustedRoot = &inviterKeyable
-		} else {
+		inviterKeyable, isRoot := creatorTrusted[currentKeyable.InvitedById]
+		if !isRoot {
+			var ok bool
 			inviterKeyable, ok = trustedKeyables[currentKeyable.InvitedById]
 			if !ok {
-				return nil, errors.New("No trusted root.")
+				pathErr = errors.New("No trusted root.")
+				break
 			}
 		}
 
-		err := currentKeyable.VerifyInviter(&inviterKeyable)
-		if err != nil {
-			return nil, err
+		inviterLink := newLink(currentKeyable.InvitedById, &inviterKeyable)
+		inviterLink.Root = isRoot
+		keyables = append(keyables, &inviterKeyable)
+		path = append(path, inviterLink)
+
+		if isRoot {
+			break
 		}
+	}
 
-		// currentKeyable now verified
-		checked[currentKeyable.InvitedById] = true
-		newlyVerified = append(newlyVerified, currentKeyable)
+	verifications := []func() error{}
+	for i := 0; i < len(keyables)-1; i++ {
+		invited, inviter := keyables[i], keyables[i+1]
+		verifications = append(verifications, func() error {
+			return invited.VerifyInviter(inviter)
+		})
+	}
 
-		if trustedRoot == nil {
-			currentKeyable = &inviterKeyable
+	for i, err := range parallel.Errors(verifications...) {
+		if err != nil {
+			path = path[:i+1]
+			path[i].Error = err.Error()
+			return nil, path, err
 		}
 	}
 
-	if trustedRoot == nil {
-		return nil, errors.New("No trusted root.")
+	if pathErr != nil {
+		path[len(path)-1].Error = pathErr.Error()
+		return nil, path, pathErr
 	}
 
-	return newlyVerified, nil
+	return keyables[:len(keyables)-1], path, nil
 }
 
 type TrustedKeyablesChain struct {
 	CreatorTrusted                    TrustedKeyablesMap
 	SignerTrusted                     TrustedKeyablesMap
 	InheritanceOverridesSignerTrusted TrustedKeyablesMap
+
+	// optional local restrictions on signers and roots
+	Policy *Policy
 }
 
 func (trustedKeyables *TrustedKeyablesChain) VerifySignerTrusted(signer *Signer) error {
@@ -123,52 +162,103 @@ func (trustedKeyables *TrustedKeyablesChain) VerifySignerTrusted(signer *Signer)
 }
 
 func (trustedKeyables *TrustedKeyablesChain) SignerTrustedKeyable(signer *Signer) (*TrustedKeyable, []*TrustedKeyable, error) {
+	trusted, newlyVerified, _, err := trustedKeyables.signerTrust(signer)
+	return trusted, newlyVerified, err
+}
+
+// Explain describes how signer is found trusted, or the step where that fails. It follows the same steps as SignerTrustedKeyable.
+func (trustedKeyables *TrustedKeyablesChain) Explain(signer *Signer) *SignerTrust {
+	_, _, explanation, _ := trustedKeyables.signerTrust(signer)
+	return explanation
+}
+
+func (trustedKeyables *TrustedKeyablesChain) signerTrust(signer *Signer) (*TrustedKeyable, []*TrustedKeyable, *SignerTrust, error) {
 	var err error
 	var trusted *TrustedKeyable
 	var newlyVerified []*TrustedKeyable
 
+	explanation := newSignerTrust(signer)
+	fail := func(err error) (*TrustedKeyable, []*TrustedKeyable, *SignerTrust, error) {
+		explanation.Error = err.Error()
+		return nil, nil, explanation, err
+	}
+
+	if signer == nil {
+		return fail(errNoSigner)
+	}
+
+	err = trustedKeyables.Policy.CheckSigner(signer.Id, explanation.Fingerprint)
+	if err != nil {
+		return fail(err)
+	}
+
 	// First check if key is present in CreatorTrusted keys, which means it's trusted, so we can return
+	explanation.lookup(trustedKeyables.CreatorTrusted, ListedInCreatorTrusted)
 	trusted, err = trustedKeyables.CreatorTrusted.SignerTrustedKeyable(signer)
 	if err != nil {
-		return nil, nil, err
+		return fail(err)
 	} else if trusted != nil {
-		return trusted, []*TrustedKeyable{}, nil
+		root := newLink(signer.Id, trusted)
+		root.Root = true
+		explanation.Path = []*Link{root}
+		err = trustedKeyables.checkRoot(root)
+		if err != nil {
+			return fail(err)
+		}
+		explanation.Trusted = true
+		return trusted, []*TrustedKeyable{}, explanation, nil
 	}
 
 	if signer.IsInheritanceSigner {
 		if trustedKeyables.InheritanceOverridesSignerTrusted == nil {
-			return nil, nil, errors.New("Inheritance overrides signer not trusted.")
+			return fail(errors.New("Inheritance overrides signer not trusted."))
 		}
 
 		// If inheritance overrides signer, find key in InheritanceOverridesSignerTrusted
+		explanation.lookup(trustedKeyables.InheritanceOverridesSignerTrusted, ListedInInheritanceOverridesSignerTrusted)
 		trusted, err = trustedKeyables.InheritanceOverridesSignerTrusted.SignerTrustedKeyable(signer)
 		if err != nil {
-			return nil, nil, err
+			return fail(err)
 		} else if trusted == nil {
-			return nil, nil, errors.New("Inheritance overrides signer not trusted.")
+			return fail(errors.New("Inheritance overrides signer not trusted."))
 		}
 
 		// Then attempt to validate trust chain back to a CreatorTrusted key
-		newlyVerified, err = trustedKeyables.InheritanceOverridesSignerTrusted.TrustedRoot(trusted, trustedKeyables.CreatorTrusted)
+		newlyVerified, explanation.Path, err = trustedKeyables.InheritanceOverridesSignerTrusted.trustedRoot(signer.Id, trusted, trustedKeyables.CreatorTrusted)
 		if err != nil {
-			return nil, nil, err
+			return fail(err)
 		}
 
 	} else {
 		// If env signer, find key in InheritanceOverridesSignerTrusted (checking only InheritanceOverridesSignerTrusted keys)
+		explanation.lookup(trustedKeyables.SignerTrusted, ListedInSignerTrusted)
 		trusted, err = trustedKeyables.SignerTrusted.SignerTrustedKeyable(signer)
 		if err != nil {
-			return nil, nil, err
+			return fail(err)
 		} else if trusted == nil {
-			return nil, nil, errors.New("Signer not trusted.")
+			return fail(errors.New("Signer not trusted."))
 		}
 
 		// Then attempt to validate trust chain back to a CreatorTrusted key (checking only SignerTrusted keys)
-		newlyVerified, err = trustedKeyables.SignerTrusted.TrustedRoot(trusted, trustedKeyables.CreatorTrusted)
+		newlyVerified, explanation.Path, err = trustedKeyables.SignerTrusted.trustedRoot(signer.Id, trusted, trustedKeyables.CreatorTrusted)
 		if err != nil {
-			return nil, nil, err
+			return fail(err)
 		}
 	}
 
-	return trusted, newlyVerified, nil
+	err = trustedKeyables.checkRoot(explanation.Path[len(explanation.Path)-1])
+	if err != nil {
+		return fail(err)
+	}
+
+	explanation.Trusted = true
+	return trusted, newlyVerified, explanation, nil
+}
+
+func (trustedKeyables *TrustedKeyablesChain) checkRoot(root *Link) error {
+	err := trustedKeyables.Policy.CheckRoot(root.Id, root.Fingerprint)
+	if err != nil {
+		root.Error = err.Error()
+	}
+	return err
 }
@@ -0,0 +1,83 @@
+package trust_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/envkey/envkey-fetch/trust"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilSigner(t *testing.T) {
+	_, _, err := trustedKeyables.SignerTrustedKeyable(nil)
+	assert.NotNil(t, err, "Should return an error.")
+	assert.False(t, trustedKeyables.Explain(nil).Trusted, "Should not be trusted.")
+
+	_, err = trustedKeyables.SignerTrusted.TrustedRoot(nil, trustedKeyables.CreatorTrusted)
+	assert.NotNil(t, err, "Should return an error.")
+}
+
+func FuzzTrustedKeyablesMap(f *testing.F) {
+	creatorTrusted, _ := json.Marshal(trustedKeyables.CreatorTrusted)
+	signerTrusted, _ := json.Marshal(trustedKeyables.SignerTrusted)
+	f.Add(creatorTrusted, signerTrusted, "admin-id", false)
+	f.Add(creatorTrusted, signerTrusted, "dev-id", true)
+	f.Add([]byte(`{"a":{"pubkey":"","invitedById":"a"}}`), []byte(`{"b":{"invitedById":"b"}}`), "b", false)
+	signerPubkeys := []string{adminPubkey, devPubkey, invalidPubkey}
+	f.Fuzz(func(t *testing.T, creatorJson, signerJson []byte, signerId string, isInheritanceSigner bool) {
+		var creator, signerMap trust.TrustedKeyablesMap
+		if json.Unmarshal(creatorJson, &creator) != nil || json.Unmarshal(signerJson, &signerMap) != nil {
+			return
+		}
+		chain := trust.TrustedKeyablesChain{
+			CreatorTrusted:                    creator,
+			SignerTrusted:                     signerMap,
+			InheritanceOverridesSignerTrusted: signerMap,
+		}
+		for _, pubkey := range signerPubkeys {
+			signer, err := trust.NewSigner(signerId, pubkey, isInheritanceSigner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain.SignerTrustedKeyable(signer)
+			chain.Explain(signer)
+		}
+		for _, keyable := range signerMap {
+			signerMap.TrustedRoot(&keyable, creator)
+		}
+		creator.Fingerprints()
+	})
+}
+
+func FuzzParsePolicyFile(f *testing.F) {
+	f.Add([]byte(`{"default":{"root_fingerprints":["ab:cd"],"deny_signer_ids":["x"]},"envkeys":{"k":{}}}`))
+	f.Add([]byte(`{"envkeys":{"k":null}}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		policyFile, err := trust.ParsePolicyFile(data)
+		if err != nil {
+			return
+		}
+		policy := policyFile.For("k-host")
+		policy.CheckSigner("x", "abcd")
+		policy.CheckRoot("x", "abcd")
+	})
+}
+
+func FuzzParseKnownSigners(f *testing.F) {
+	f.Add([]byte("# comment\nname root owner-id abcd\nname signer dev-id ef01\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		knownSigners, err := trust.ParseKnownSigners(data)
+		if err != nil {
+			return
+		}
+		knownSigners.Format()
+		seen := trust.NewKnown()
+		seen.Roots["owner-id"] = "abcd"
+		for _, known := range knownSigners {
+			known.Check(seen)
+			known.Merge(seen)
+			known.Accept(seen)
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package trust_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/envkey/envkey-fetch/trust"
@@ -73,6 +75,158 @@ func TestTrustedKeyablesChainSignerTrustedKeyable(t *testing.T) {
 	assert.NotNil(t, err, "Should return an error.")
 }
 
+func TestTrustedKeyablesChainExplain(t *testing.T) {
+	var explanation *trust.SignerTrust
+
+	// Deep trust chain
+	explanation = trustedKeyables.Explain(devInheritanceSigner)
+	assert.True(t, explanation.Trusted, "Should be trusted.")
+	assert.Equal(t, trust.ListedInInheritanceOverridesSignerTrusted, explanation.ListedIn)
+	assert.Equal(t, explanation.Fingerprint, explanation.TrustedFingerprint, "Fingerprints should match.")
+	if assert.Len(t, explanation.Path, 3) {
+		assert.Equal(t, "dev-id", explanation.Path[0].Id)
+		assert.Equal(t, "admin-id", explanation.Path[0].InvitedById)
+		assert.Equal(t, "admin-id", explanation.Path[1].Id)
+		assert.Equal(t, "owner-id", explanation.Path[2].Id)
+		assert.True(t, explanation.Path[2].Root, "Should end at a root.")
+		for _, link := range explanation.Path {
+			assert.Empty(t, link.Error, "Each link should verify.")
+			assert.NotEmpty(t, link.Fingerprint, "Each link should have a fingerprint.")
+		}
+	}
+
+	// Creator trusted signer
+	ownerSigner, _ := trust.NewSigner("owner-id", ownerPubkey, false)
+	explanation = trustedKeyables.Explain(ownerSigner)
+	assert.True(t, explanation.Trusted, "Should be trusted.")
+	assert.Equal(t, trust.ListedInCreatorTrusted, explanation.ListedIn)
+	if assert.Len(t, explanation.Path, 1) {
+		assert.True(t, explanation.Path[0].Root, "Should be a root.")
+	}
+
+	// Not listed
+	missingSigner, _ := trust.NewSigner("missing-id", devPubkey, false)
+	explanation = trustedKeyables.Explain(missingSigner)
+	assert.False(t, explanation.Trusted, "Should not be trusted.")
+	assert.Empty(t, explanation.ListedIn)
+	assert.Equal(t, "Signer not trusted.", explanation.Error)
+
+	// Fingerprint mismatch
+	impostorSigner, _ := trust.NewSigner("admin-id", devPubkey, false)
+	explanation = trustedKeyables.Explain(impostorSigner)
+	assert.False(t, explanation.Trusted, "Should not be trusted.")
+	assert.Equal(t, trust.ListedInSignerTrusted, explanation.ListedIn)
+	assert.NotEqual(t, explanation.Fingerprint, explanation.TrustedFingerprint, "Fingerprints should differ.")
+	assert.Empty(t, explanation.Path)
+
+	// Invite signed by the wrong key
+	explanation = trustedKeyables.Explain(invalidSigner)
+	assert.False(t, explanation.Trusted, "Should not be trusted.")
+	assert.NotEmpty(t, explanation.Error)
+	if assert.Len(t, explanation.Path, 1) {
+		assert.Equal(t, "admin-id", explanation.Path[0].InvitedById)
+		assert.Equal(t, explanation.Error, explanation.Path[0].Error, "Should fail at the first link.")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	var err error
+
+	ownerFingerprint := trustedKeyables.Explain(adminSigner).Path[1].Fingerprint
+	adminFingerprint := trustedKeyables.Explain(adminSigner).Fingerprint
+
+	policyChain := func(policy *trust.Policy) trust.TrustedKeyablesChain {
+		chain := trustedKeyables
+		chain.Policy = policy
+		return chain
+	}
+
+	// Pinned root
+	chain := policyChain(&trust.Policy{RootFingerprints: []string{strings.ToUpper(ownerFingerprint)}})
+	err = chain.VerifySignerTrusted(adminSigner)
+	assert.Nil(t, err, "Should not return an error.")
+	err = chain.VerifySignerTrusted(devInheritanceSigner)
+	assert.Nil(t, err, "Should not return an error.")
+
+	// Root not pinned
+	chain = policyChain(&trust.Policy{RootFingerprints: []string{adminFingerprint}})
+	err = chain.VerifySignerTrusted(adminSigner)
+	assert.True(t, errors.Is(err, trust.ErrPolicy), "Should be rejected by policy.")
+	explanation := chain.Explain(adminSigner)
+	assert.False(t, explanation.Trusted)
+	assert.Equal(t, explanation.Error, explanation.Path[len(explanation.Path)-1].Error, "Should fail at the root.")
+
+	// Denied by id and by fingerprint
+	chain = policyChain(&trust.Policy{DenySignerIds: []string{"admin-id"}})
+	err = chain.VerifySignerTrusted(adminSigner)
+	assert.True(t, errors.Is(err, trust.ErrPolicy), "Should be rejected by policy.")
+	err = chain.VerifySignerTrusted(devInheritanceSigner)
+	assert.Nil(t, err, "Only the signer should be checked.")
+
+	chain = policyChain(&trust.Policy{DenySignerFingerprints: []string{adminFingerprint}})
+	err = chain.VerifySignerTrusted(adminSigner)
+	assert.True(t, errors.Is(err, trust.ErrPolicy), "Should be rejected by policy.")
+
+	// Allow lists
+	chain = policyChain(&trust.Policy{AllowSignerIds: []string{"dev-id"}})
+	err = chain.VerifySignerTrusted(adminSigner)
+	assert.True(t, errors.Is(err, trust.ErrPolicy), "Should be rejected by policy.")
+	err = chain.VerifySignerTrusted(devInheritanceSigner)
+	assert.Nil(t, err, "Should not return an error.")
+
+	// Per ENVKEY policies replace the default
+	policyFile, err := trust.ParsePolicyFile([]byte(`{"default":{"deny_signer_ids":["admin-id"]},"envkeys":{"key1":{}}}`))
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"admin-id"}, policyFile.For("key2").DenySignerIds)
+		assert.Empty(t, policyFile.For("key1").DenySignerIds)
+	}
+
+	_, err = trust.ParsePolicyFile([]byte(`{"default":{"deny_signers":["admin-id"]}}`))
+	assert.NotNil(t, err, "Should reject unknown fields.")
+}
+
+func TestKnownSigners(t *testing.T) {
+	known := trust.NewKnown()
+	known.Roots["owner-id"] = "aa"
+	known.Signers["admin-id"] = "bb"
+
+	// Round trip
+	knownSigners, err := trust.ParseKnownSigners(trust.KnownSigners{"key1": known}.Format())
+	if assert.Nil(t, err) {
+		assert.Equal(t, trust.KnownSigners{"key1": known}, knownSigners)
+	}
+
+	_, err = trust.ParseKnownSigners([]byte("key1 admin owner-id aa\n"))
+	assert.NotNil(t, err, "Should reject unknown kinds.")
+
+	// New signers are updates, root and signer key changes are alerts
+	seen := trust.NewKnown()
+	seen.Roots["owner-id"] = "AA"
+	seen.Signers["dev-id"] = "cc"
+	alerts, updates := known.Check(seen)
+	assert.Empty(t, alerts)
+	assert.Len(t, updates, 1)
+
+	seen.Roots["owner-id"] = "dd"
+	seen.Signers["admin-id"] = "ee"
+	alerts, _ = known.Check(seen)
+	assert.Len(t, alerts, 2)
+
+	seen = trust.NewKnown()
+	seen.Roots["new-owner-id"] = "ff"
+	alerts, updates = known.Check(seen)
+	if assert.Len(t, alerts, 1) {
+		assert.Equal(t, "new root new-owner-id with fingerprint ff", alerts[0].String())
+	}
+	assert.Len(t, updates, 1, "The old root being gone should only be an update.")
+
+	// Accepting replaces roots and keeps signers
+	changes := known.Accept(seen)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, map[string]string{"new-owner-id": "ff"}, known.Roots)
+	assert.Equal(t, map[string]string{"admin-id": "bb"}, known.Signers)
+}
+
 var ownerPubkey = "-----BEGIN PGP PUBLIC KEY BLOCK-----\r\nVersion: OpenPGP.js v2.5.4\r\nComment: http://openpgpjs.org\r\n\r\nxsBNBFmCzjMBCAC6y3B/mkv5d5K77MMKxOqbAq88cdCCQk6BQ8KlW1WD07af\n9f2LUnyzPfsguCZTIGaT527eYJYZhbELvAmo3w3L2yMZq/LniBQv41QE2H05\nm2khLeREGcX6dEoPauJz6Fqfg/4VAdovFEbmYCzIfahd/8sxMtaSIX4KMfoN\nyLP8MDM6ujFPGKNLGvArXqsUYb1Hi4nJZOI5vBvLIzMX3jUAJxU+UxO+oKiU\nc994OboSvU6ANdjuGmK5y8MvaHco+SZ+NiijEq8EJDr6hRmivJ+5fvISjKP7\nDpaotN7BWTS02BqmNauSFFFbh0aMSAdU3uIhTP1/9uib1KgKS7j3QGhtABEB\nAAHNjjk5MmY1MThkODlmMDNiNWI1MTYwYjNmNGU4ZjA2ZDEyNDBlYWQ3ZDE5\nNDliNDVmYmMwMjJjNTNhNGE2MDk3ZmYgPDk5MmY1MThkODlmMDNiNWI1MTYw\nYjNmNGU4ZjA2ZDEyNDBlYWQ3ZDE5NDliNDVmYmMwMjJjNTNhNGE2MDk3ZmZA\nZW52a2V5LmNvbT7CwHUEEAEIACkFAlmCzjQGCwkHCAMCCRB+EhPA1+WGuwQV\nCAoCAxYCAQIZAQIbAwIeAQAAw6QH/iUlSG5zmUyUihvh4IVdAqjtGPcLOxxO\nVzhLYQRTuHbgj/8JZ2/XRvFXAf+XH30a/PElDOofaBPEkU5JBKt1t4/D2cn1\no40pSpOpqnatTZba93/awvfU7lKY+KU4XWh47ynefdLjpBkdfLbAhBel8RAF\n9Jcwf2/rSCP9WghFxYnBxcTWTq8X7ic5A90yln0VagbgbLZEFzWkgpLauBaq\n9bYU5KPwSamdQmW0U2KlZQdJB5j3/NGT5SNn/YY3eYjTJtKwgEjyeUGsFhqq\nRrmdgF9k+lOJ32fBwrPMUgaDZfk2c89IoEcW3n8u9Vh2apPUhGbBnj4+KFzD\nYTHJnRBmyRrOwE0EWYLOMwEIANSYbzN7fyExhv2fjrXrY/5UuWFUPpnLB6sJ\npr5Y5v6LrJWAo9mcuFqpfciZTqbhbM2SCE7x+npbMg2qS5ZZRt/7ZQGodaaw\n/CWl51wbAhF3g2qKg/N+OxLfzePxG+gz7U4kUdxdQfvkcXK5RCoOEn0wMRGI\n/JyHQNJT12GCF/XYY8Tj9jWR3nMN23FN0A5T4wYEWei3ReXb9hUFKopc97la\ngkGjSyXbYZOH5XncBQ4dq4KuU661O5QyGGA0Kc8wjC7PTB+5vVonEio15eoM\nB8H94zwf5PAo8tEClKOw/WBtDk8rs60V/i1kDELIMlHjxp5qYFOlxAO4/VVw\nPb5vOfsAEQEAAcLAXwQYAQgAEwUCWYLONQkQfhITwNflhrsCGwwAAPNZB/0Z\noMNT2B1uzAK82EpD6BZjyjeejDEQZu0sDwwihBe0b9KhaJJNOiSVK+CMC6JF\n0OexaQBRdFS0FReofVbM35oOLc+X7XVAzmt9+3AcgsxLcqduVYSz9HfI7MdD\n5FS+QiGhExlLVNY/CAVtE+2/oNunDQszdHK31I7FQU+8QKnMt2UEOtrdfXby\ncBfKb7P/8EU4IlJP98qepXn4m29PgrxXiTwXl5lJYcZ0ilVmJuf9JfcMMggJ\nbS1PtNOd1h1JA8THRcsbMnRJOfOfcujwthGlUrPQGKfOZnnT70hY+/ohGJLT\nD0C+/TSjGDWsEHrK16YpQ5xfOoPZvh3HsYzpAqjR\r\n=XXQ6\r\n-----END PGP PUBLIC KEY BLOCK-----\r\n\r\n"
 
 var adminInvitePubkey = "-----BEGIN PGP PUBLIC KEY BLOCK-----\r\nVersion: OpenPGP.js v2.5.4\r\nComment: http://openpgpjs.org\r\n\r\nxsBNBFmC0DABCADPY1LFsN5ismxcMoakQHsI99vqVPuL4gl8XQjwKuhauDyv\ncfv/TidY4ByvT345kGuKEr1aO7hhQq7zMR4UEr7LFDvxKS/tUP3iDKctojV+\nVEP6pJXNEz6sjRDsBORBgcbQtO6+WVA5WJdajnRO9gvJekYW2oDenZec/Qti\nsYbY42L3X6sHISFeVaE2XxMgOw7kjMDeeTnkjIoMK7jwl6JWJluLzijaZcj1\niB65npGPqPcF1T/lrLlvoZhynaz15GURgs2FfPG30Fh1pnJR/WQyLrQhRCMi\nt6oHsBKS+ALkCqXex5hybwI3hFkVSF/PJNWp7ZbI7/uRiyiekOPP8HuZABEB\nAAHNjmNjZDU3NjUyNTM5MWM3ZjZkMWNjNzlhOTYxZjc3NWU3NmJjNWM2NTNi\nNTk0NDIzNzdiMmNhYWZiZDEyNzg0OGEgPGNjZDU3NjUyNTM5MWM3ZjZkMWNj\nNzlhOTYxZjc3NWU3NmJjNWM2NTNiNTk0NDIzNzdiMmNhYWZiZDEyNzg0OGFA\nZW52a2V5LmNvbT7CwHUEEAEIACkFAlmC0DEGCwkHCAMCCRBxjuox5N0ODwQV\nCAoCAxYCAQIZAQIbAwIeAQAATCcH/jcDS+WYGa21UP01D13FR2ZDW63cxT3F\nH7EIjHQvig3VcVBIFQF/kGkJ4B36dfgOCFxplumy56hkUQ7OQ6Ev5wQwsF0J\nI4UydleTr/KqK9rY2UPh4V29HxrmBe0iVu2CPLYHzLqfHGDLK5LNlRgpPgQZ\nkdNJJOPQIUn4HQ10O8fZf9MoNjv8NSs+kFtQXQp/cwuh+hPXaqG9j5f/EYwp\ngMem0KDdMcHZ8/x2++LCgjsy07fGczXX6W94Uy8Yr9tRBqXC3oj/Q0eNA+lf\nwvW8ZSf2r+2dATYgelyCoY18yYwITTn2sbxNkVsNT2df1KnA6WcIWTVErlJ8\nyiq0+p1foBrCwF8EEAEIABMFAlmC0DIJEH4SE8DX5Ya7AhsDAADvGwf6AuhP\ntGq2sUbIZx4GdpZ6qu8OgcmSLhpgaDpuCiassm9zCjm/FkxwliywMlGCCMGR\naQVnVJPgGSFvrLpAoCL6qSOb5JbxXi4JQYZM2qlBzwcwpnF1R1Pcm5VNL1gu\n6kLy5+Xc0iM+JMsSlDU8SFunypASVzlgviPYLzpGLsXJREFGq4MCTnzk1iaQ\ncOMsHSQP72/re8K1XX3Prhz1uxBLrbmNB98jlZzW5D43b+J87gmsI8TXF65r\nRxtssyp81G26z1px/yCtyQN6Qe/kxlbO6drnRlk+u20LkhI1m0P15Z8CrwLM\nVNEcSRGxEsZVxJWfY13OjNiWuDNIKjqOI3us6M7ATQRZgtAwAQgArPUe9IPT\ncpMBb+k0SavL9h0Gux3waAwjgvMWjO0mJ/BT6z6At51OVQVf492MLuZ+UmDA\nLZSDksRryvRETn/dQ7apOtwpsYfb0XZQjhX5svP0VhtqrWqoTMkClhVeQ4Oe\nhEM/g1ph6OB4OqAOXZ7RBoAv37X4ekUF/Onp61wZqudxRlVb0v/gZDtl1KHt\nuDuhipvMfUNaV4CIOzbmPIdrjTYneY9KEp/zHPAj9DEjOOu6MjImuhdFTEXE\nCn2uXY5tLLBhcMb/CcURfF8qI4i5oebfTBSVYjipQtVONCNsWvG54PVpzv3S\nLngmoHQ9807lnLmFeC8Eah4zovFDGOteyQARAQABwsBfBBgBCAATBQJZgtAx\nCRBxjuox5N0ODwIbDAAA0JoH/2hCMx4Biod77+UMUBIA526XLTwrVYSfO2kU\n89a5ClJh3ynKdZShA1cD9IZm+tcegBITs2puOw2MPwWw16AZCu1OP3y4lScI\nhWiMF1WfQ24PB+nhto5Z/ITTxX1OtJcglow1lnFa6uWu53XCbwuGAR7+FhdC\nA9vYBLgAotI6/feOK8btx5MjaYxogh5x0Mk/CwhVbIZ7ao0lFneqdfmoa11F\nRhGKsEVl5hcckgqM/6nIcNO5r/W3HZt+T3jUVTIlNA6+DikGQiHCHfXmlAd8\nPxS8HlLF6UkWpRcT8DBvMtDEbKjwnxD+UxwIDxW0/rzeaVlx50jL6ePrXJ8v\nsajdogs=\r\n=GNbh\r\n-----END PGP PUBLIC KEY BLOCK-----\r\n\r\n"